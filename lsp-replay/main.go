@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/piot/lsp-server/lspserv/lspreplay"
+)
+
+// processReadWriteCloser talks to a language server process over its stdio.
+type processReadWriteCloser struct {
+	stdout io.ReadCloser
+	stdin  io.WriteCloser
+}
+
+func (p processReadWriteCloser) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+func (p processReadWriteCloser) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+func (p processReadWriteCloser) Close() error {
+	return p.stdin.Close()
+}
+
+func main() {
+	transcriptPath := flag.String("transcript", "", "JSON lines file recorded with -record or LSPSERV_RECORD")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for each response")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: lsp-replay -transcript session.jsonl [-timeout 5s] -- server [args...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *transcriptPath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(*transcriptPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transcript, err := lspreplay.ReadTranscript(file)
+	file.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := lspreplay.Replay(processReadWriteCloser{stdout: stdout, stdin: stdin}, transcript, *timeout)
+	stdin.Close()
+	cmd.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, diff := range report.Diffs {
+		fmt.Println(diff)
+	}
+	fmt.Printf("%d requests replayed, %d differ\n", report.Requests, len(report.Diffs))
+
+	if len(report.Diffs) > 0 {
+		os.Exit(1)
+	}
+}
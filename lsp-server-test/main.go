@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"os"
 
 	"github.com/piot/go-lsp"
 
 	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
 )
 
 type MyHandler struct {
@@ -373,17 +378,19 @@ func (m *MyHandler) HandleCodeLens(params lsp.CodeLensParams, conn lspserv.Conne
 	}, nil
 }
 
+func (m *MyHandler) SemanticTokensLegend() lsp.SemanticTokensLegend {
+	return lsp.SemanticTokensLegend{
+		TokenTypes:     []string{"namespace", "enum", "keyword", "macroInvocation"},
+		TokenModifiers: []string{"declaration", "definition", "mutable"},
+	}
+}
+
 func (m *MyHandler) HandleSemanticTokensFull(params lsp.SemanticTokensParams, conn lspserv.Connection) (*lsp.SemanticTokens, error) {
-	//						TokenTypes:     []string{"type", "enum", "struct", "typeParameter", "parameter"},
-	//TokenModifiers: []string{"declaration", "definition"},
-	// deltaLine, deltaColumn, Length, tokenType, tokenModifierFlags
-	return &lsp.SemanticTokens{
-		ResultId: "",
-		Data: []uint{
-			0, 0, 5, 0, 1,
-			2, 0, 5, 3, 2,
-		},
-	}, nil
+	builder := conn.SemanticTokensBuilder("")
+	builder.Add(0, 0, 5, "namespace", "declaration")
+	builder.Add(2, 0, 5, "enum", "definition")
+
+	return builder.Build()
 }
 
 func (m *MyHandler) HandleCodeLensResolve(params lsp.CodeLens, conn lspserv.Connection) (*lsp.CodeLens, error) {
@@ -408,8 +415,46 @@ func (m *MyHandler) HandleCodeLensResolve(params lsp.CodeLens, conn lspserv.Conn
 }
 
 func main() {
+	recordPath := flag.String("record", "", "record all messages of the session to this JSON lines file")
+	conformance := flag.Bool("conformance", false, "run the protocol conformance suite against the handler and exit")
+	flag.Parse()
+
+	if *conformance {
+		failures := lspservtest.RunConformance(func() lspserv.Handler { return &MyHandler{} })
+		for _, failure := range failures {
+			fmt.Println(failure)
+		}
+		if len(failures) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	testHandler := &MyHandler{}
 	service := lspserv.NewService(testHandler)
+	service.RegisterCommand("swamp.somecommand", lspserv.TypedCommand(func(ctx context.Context) (interface{}, error) {
+		log.Printf("swamp.somecommand executed")
+		return nil, nil
+	}))
+
+	var recorder *lspserv.Recorder
+	if *recordPath != "" {
+		var err error
+		recorder, err = lspserv.NewFileRecorder(*recordPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		service.RecordTo(recorder)
+	}
+
+	err := service.RunUntilClose(lspserv.StdInOutReadWriteCloser{}, true)
+
+	if recorder != nil {
+		recorder.Close()
+	}
 
-	service.RunUntilClose(lspserv.StdInOutReadWriteCloser{}, true)
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+func TestConformance(t *testing.T) {
+	for _, failure := range lspservtest.RunConformance(func() lspserv.Handler { return &MyHandler{} }) {
+		t.Error(failure)
+	}
+}
@@ -0,0 +1,42 @@
+package lspserv
+
+import "github.com/piot/go-lsp"
+
+// CallHierarchyItem is a function or method in the call hierarchy view.
+type CallHierarchyItem = HierarchyItem
+
+type CallHierarchyIncomingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+// CallHierarchyIncomingCall is a caller of the item, with the ranges of the calls inside From.
+type CallHierarchyIncomingCall struct {
+	From       CallHierarchyItem `json:"from"`
+	FromRanges []lsp.Range       `json:"fromRanges"`
+}
+
+type CallHierarchyOutgoingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+// CallHierarchyOutgoingCall is a function called by the item, with the ranges of the calls inside the item.
+type CallHierarchyOutgoingCall struct {
+	To         CallHierarchyItem `json:"to"`
+	FromRanges []lsp.Range       `json:"fromRanges"`
+}
+
+// CallHierarchyHandler can optionally be implemented by a Handler to serve `textDocument/prepareCallHierarchy`,
+// `callHierarchy/incomingCalls` and `callHierarchy/outgoingCalls`.
+type CallHierarchyHandler interface {
+	HandlePrepareCallHierarchy(params lsp.TextDocumentPositionParams, conn Connection) ([]CallHierarchyItem, error)
+	HandleCallHierarchyIncomingCalls(params CallHierarchyIncomingCallsParams, conn Connection) ([]CallHierarchyIncomingCall, error)
+	HandleCallHierarchyOutgoingCalls(params CallHierarchyOutgoingCallsParams, conn Connection) ([]CallHierarchyOutgoingCall, error)
+}
+
+func (h *HandleLspRequests) callHierarchyProvider() *lsp.CallHierarchyOptions {
+	if _, ok := h.handler.(CallHierarchyHandler); !ok {
+		return nil
+	}
+
+	return &lsp.CallHierarchyOptions{}
+}
@@ -0,0 +1,19 @@
+package lspserv
+
+import "github.com/piot/go-lsp"
+
+// ServerCapabilities adds the capabilities that lsp.ServerCapabilities is missing, or can not express.
+// Fields declared here take precedence over the embedded fields with the same JSON name.
+type ServerCapabilities struct {
+	lsp.ServerCapabilities
+
+	// WorkspaceSymbolProvider is either true or WorkspaceSymbolOptions.
+	WorkspaceSymbolProvider interface{}           `json:"workspaceSymbolProvider,omitempty"`
+	TypeHierarchyProvider   *TypeHierarchyOptions `json:"typeHierarchyProvider,omitempty"`
+	InlayHintProvider       *InlayHintOptions     `json:"inlayHintProvider,omitempty"`
+	DiagnosticProvider      *DiagnosticOptions    `json:"diagnosticProvider,omitempty"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
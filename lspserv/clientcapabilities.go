@@ -0,0 +1,22 @@
+package lspserv
+
+// clientCapabilities are the client capabilities that the dispatcher acts on. They are decoded from the
+// initialize params separately, since lsp.ClientCapabilities is missing most of them.
+type clientCapabilities struct {
+	TextDocument struct {
+		FoldingRange struct {
+			RangeLimit      int  `json:"rangeLimit"`
+			LineFoldingOnly bool `json:"lineFoldingOnly"`
+		} `json:"foldingRange"`
+		SemanticTokens struct {
+			TokenTypes            []string `json:"tokenTypes"`
+			TokenModifiers        []string `json:"tokenModifiers"`
+			MultilineTokenSupport bool     `json:"multilineTokenSupport"`
+		} `json:"semanticTokens"`
+	} `json:"textDocument"`
+	Workspace struct {
+		InlayHint struct {
+			RefreshSupport bool `json:"refreshSupport"`
+		} `json:"inlayHint"`
+	} `json:"workspace"`
+}
@@ -0,0 +1,94 @@
+package lspserv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+)
+
+// CommandFunc runs a command that the client executes with `workspace/executeCommand`, e.g. from a code lens.
+// The Connection is available through ConnectionFromContext.
+type CommandFunc func(ctx context.Context, arguments []json.RawMessage) (interface{}, error)
+
+// executeCommandParams keeps the arguments undecoded, unlike lsp.ExecuteCommandParams.
+type executeCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// RegisterCommand serves the command and lists it in the capabilities. Must be called before RunUntilClose.
+func (h *HandleLspRequests) RegisterCommand(name string, fn CommandFunc) {
+	h.commands[name] = fn
+}
+
+// executeCommandProvider lists the registered commands, or is nil if there are none.
+func (h *HandleLspRequests) executeCommandProvider() *lsp.ExecuteCommandOptions {
+	if len(h.commands) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(h.commands))
+	for name := range h.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &lsp.ExecuteCommandOptions{Commands: names}
+}
+
+func (h *HandleLspRequests) executeCommand(ctx context.Context, params executeCommandParams, conn Connection) (interface{}, error) {
+	fn, ok := h.commands[params.Command]
+	if !ok {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("HandleLspRequests: unknown command %q", params.Command)}
+	}
+
+	ctx = context.WithValue(ctx, connectionContextKey{}, conn)
+	ctx = context.WithValue(ctx, decodeContextKey{}, decodeOptions{method: params.Command, strict: h.strictParams})
+
+	return fn(ctx, params.Arguments)
+}
+
+// DecodeArguments unmarshals the arguments of a command into targets, in order, and reports a failure or a
+// missing argument as an InvalidParams error.
+func DecodeArguments(ctx context.Context, arguments []json.RawMessage, targets ...interface{}) error {
+	options, _ := ctx.Value(decodeContextKey{}).(decodeOptions)
+
+	if len(arguments) < len(targets) {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("%s: expected %d arguments, got %d", options.method, len(targets), len(arguments))}
+	}
+
+	for index, target := range targets {
+		if err := decodeParams(fmt.Sprintf("%s argument %d", options.method, index), arguments[index], target, options.strict); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TypedCommand adapts fn with the signature `func(ctx context.Context, arg0 A0, arg1 A1, ...) (R, error)` to a
+// CommandFunc, which decodes each argument before calling fn. It panics if fn has another signature.
+func TypedCommand(fn interface{}) CommandFunc {
+	fnValue, argumentTypes := typedFunc(fn, -1, 2)
+
+	return func(ctx context.Context, arguments []json.RawMessage) (interface{}, error) {
+		in := []reflect.Value{reflect.ValueOf(ctx)}
+		targets := make([]interface{}, 0, len(argumentTypes))
+		for _, argumentType := range argumentTypes {
+			target := reflect.New(argumentType)
+			in = append(in, target.Elem())
+			targets = append(targets, target.Interface())
+		}
+
+		if err := DecodeArguments(ctx, arguments, targets...); err != nil {
+			return nil, err
+		}
+
+		return callTypedFunc(fnValue, in)
+	}
+}
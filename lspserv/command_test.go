@@ -0,0 +1,62 @@
+package lspserv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+func TestTypedCommand(t *testing.T) {
+	service := lspserv.NewService(&stubHandler{})
+	service.RegisterCommand("swamp.repeat", lspserv.TypedCommand(func(ctx context.Context, text string, count int) ([]string, error) {
+		repeated := make([]string, count)
+		for index := range repeated {
+			repeated[index] = text
+		}
+		return repeated, nil
+	}))
+
+	client := lspservtest.NewServiceClient(service)
+	defer client.Close()
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var repeated []string
+	if err := client.ExecuteCommand("swamp.repeat", &repeated, "a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(repeated) != 2 || repeated[0] != "a" {
+		t.Errorf("unexpected result %v", repeated)
+	}
+
+	if err := client.ExecuteCommand("swamp.repeat", &repeated, "a", "two"); err == nil {
+		t.Error("expected an argument of the wrong type to fail")
+	}
+
+	if err := client.ExecuteCommand("swamp.repeat", &repeated, "a"); err == nil {
+		t.Error("expected a missing argument to fail")
+	}
+}
+
+func TestTypedCommandPanicsOnWrongSignature(t *testing.T) {
+	for _, fn := range []interface{}{
+		func(text string) (string, error) { return text, nil },
+		func(ctx context.Context, text string) string { return text },
+		"not a func",
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %T to panic", fn)
+				}
+			}()
+			lspserv.TypedCommand(fn)
+		}()
+	}
+}
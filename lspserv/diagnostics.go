@@ -0,0 +1,218 @@
+package lspserv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/piot/go-lsp"
+)
+
+// AnalyzeFunc computes the diagnostics of a version of a document. ctx is cancelled when a newer version is
+// scheduled or the document is closed, and the result is then discarded.
+type AnalyzeFunc func(ctx context.Context, uri lsp.DocumentURI, version int) ([]lsp.Diagnostic, error)
+
+// DiagnosticsManager publishes the diagnostics of open documents. Analyses are debounced per document, so
+// typing only analyses the document once it has not changed for the delay. Diagnostics equal to the ones
+// already published are not sent again. It is safe for concurrent use.
+type DiagnosticsManager struct {
+	mu        sync.Mutex
+	delay     time.Duration
+	analyze   AnalyzeFunc
+	logger    Logger
+	documents map[lsp.DocumentURI]*diagnosedDocument
+}
+
+type diagnosedDocument struct {
+	conn    Connection
+	version int
+	// generation increases with every schedule, so results of superseded analyses are recognized even if the
+	// version is the same.
+	generation int
+	timer      *time.Timer
+	cancel     context.CancelFunc
+	// sequence increases with every publish and when the document is closed. A publish is skipped if another
+	// one has been claimed since, so the connection is written without holding the lock of the manager.
+	sequence       int
+	publishedID    string
+	publishedCount int
+	// publishMu orders the writes of the diagnostics of the document.
+	publishMu sync.Mutex
+}
+
+func NewDiagnosticsManager(delay time.Duration, analyze AnalyzeFunc) *DiagnosticsManager {
+	return &DiagnosticsManager{
+		delay:     delay,
+		analyze:   analyze,
+		logger:    defaultLogger,
+		documents: make(map[lsp.DocumentURI]*diagnosedDocument),
+	}
+}
+
+// SetLogger replaces the default stderr logger used for failed analyses.
+func (m *DiagnosticsManager) SetLogger(logger Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logger = logger
+}
+
+// Schedule analyses the version of the document after the delay, unless another version is scheduled before
+// that. A running analysis of an older version is cancelled.
+func (m *DiagnosticsManager) Schedule(uri lsp.DocumentURI, version int, conn Connection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	document, ok := m.documents[uri]
+	if !ok {
+		document = &diagnosedDocument{}
+		m.documents[uri] = document
+	}
+
+	document.stop()
+	document.conn = conn
+	document.version = version
+	document.generation++
+
+	generation := document.generation
+	document.timer = time.AfterFunc(m.delay, func() {
+		m.run(uri, generation)
+	})
+}
+
+// Close stops analysing the document and clears its published diagnostics.
+func (m *DiagnosticsManager) Close(uri lsp.DocumentURI, conn Connection) error {
+	m.mu.Lock()
+
+	document, ok := m.documents[uri]
+	if !ok {
+		m.mu.Unlock()
+		return nil
+	}
+
+	document.stop()
+	delete(m.documents, uri)
+	document.sequence++
+
+	if document.publishedCount == 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	version := document.version
+	m.mu.Unlock()
+
+	// Waits for a publish that is being written, so the clearing is sent after it.
+	document.publishMu.Lock()
+	defer document.publishMu.Unlock()
+
+	return conn.PublishDiagnostics(lsp.PublishDiagnosticsParams{URI: uri, Version: uint(version), Diagnostics: []lsp.Diagnostic{}})
+}
+
+// Stop cancels all scheduled and running analyses, so nothing is published to a closed connection. It is called
+// when the connection of the Service is closed.
+func (m *DiagnosticsManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for uri, document := range m.documents {
+		document.stop()
+		document.sequence++
+		delete(m.documents, uri)
+	}
+}
+
+func (d *diagnosedDocument) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if d.cancel != nil {
+		d.cancel()
+		d.cancel = nil
+	}
+}
+
+func (m *DiagnosticsManager) run(uri lsp.DocumentURI, generation int) {
+	m.mu.Lock()
+	document, ok := m.documents[uri]
+	if !ok || document.generation != generation {
+		m.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	document.cancel = cancel
+	version := document.version
+	m.mu.Unlock()
+
+	diagnostics, err := m.analyze(ctx, uri, version)
+	cancel()
+
+	if diagnostics == nil {
+		diagnostics = []lsp.Diagnostic{}
+	}
+	resultID := diagnosticsResultID(diagnostics)
+
+	m.mu.Lock()
+	document, ok = m.documents[uri]
+	if !ok || document.generation != generation {
+		m.mu.Unlock()
+		return
+	}
+	document.cancel = nil
+
+	if err != nil {
+		logger := m.logger
+		m.mu.Unlock()
+		if !errors.Is(err, context.Canceled) {
+			logger.Log(LogError, "DiagnosticsManager: analysis failed", Field("uri", uri), Field("version", version), Field("error", err))
+		}
+		return
+	}
+
+	if resultID == document.publishedID {
+		m.mu.Unlock()
+		return
+	}
+
+	document.sequence++
+	sequence := document.sequence
+	document.publishedID = resultID
+	document.publishedCount = len(diagnostics)
+	conn := document.conn
+	m.mu.Unlock()
+
+	m.publish(document, sequence, conn, lsp.PublishDiagnosticsParams{URI: uri, Version: uint(version), Diagnostics: diagnostics})
+}
+
+// publish writes the diagnostics unless a later publish has been claimed or the document has been closed in
+// the meantime.
+func (m *DiagnosticsManager) publish(document *diagnosedDocument, sequence int, conn Connection, params lsp.PublishDiagnosticsParams) {
+	document.publishMu.Lock()
+	defer document.publishMu.Unlock()
+
+	m.mu.Lock()
+	isLatest := document.sequence == sequence
+	logger := m.logger
+	m.mu.Unlock()
+
+	if !isLatest {
+		return
+	}
+
+	if err := conn.PublishDiagnostics(params); err != nil {
+		logger.Log(LogError, "DiagnosticsManager: publish failed", Field("uri", params.URI), Field("version", params.Version), Field("error", err))
+
+		m.mu.Lock()
+		if document.sequence == sequence {
+			document.publishedID = ""
+		}
+		m.mu.Unlock()
+	}
+}
+
+// SetDiagnosticsManager schedules the analysis of documents when they are opened or changed, and clears their
+// diagnostics when they are closed, after the handler has been notified. Must be called before RunUntilClose.
+func (h *HandleLspRequests) SetDiagnosticsManager(manager *DiagnosticsManager) {
+	h.diagnostics = manager
+}
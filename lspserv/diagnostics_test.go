@@ -0,0 +1,155 @@
+package lspserv_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+// publishingConnection records the published diagnostics. Publishing blocks while block is set.
+type publishingConnection struct {
+	lspserv.Connection
+
+	block     chan struct{}
+	published chan lsp.PublishDiagnosticsParams
+}
+
+func newPublishingConnection() *publishingConnection {
+	return &publishingConnection{published: make(chan lsp.PublishDiagnosticsParams, 16)}
+}
+
+func (c *publishingConnection) PublishDiagnostics(params lsp.PublishDiagnosticsParams) error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.published <- params
+	return nil
+}
+
+func (c *publishingConnection) await(t *testing.T) lsp.PublishDiagnosticsParams {
+	t.Helper()
+
+	select {
+	case params := <-c.published:
+		return params
+	case <-time.After(time.Second):
+		t.Fatal("no diagnostics were published")
+		return lsp.PublishDiagnosticsParams{}
+	}
+}
+
+func (c *publishingConnection) expectNone(t *testing.T, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case params := <-c.published:
+		t.Errorf("expected nothing to be published, got %+v", params)
+	case <-time.After(wait):
+	}
+}
+
+// analyzeVersion reports one diagnostic with the version, divided by divisor, as the message, so versions
+// can have the same diagnostics.
+type analyzeVersion struct {
+	mu       sync.Mutex
+	divisor  int
+	analyzed []int
+}
+
+func (a *analyzeVersion) analyze(ctx context.Context, uri lsp.DocumentURI, version int) ([]lsp.Diagnostic, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.analyzed = append(a.analyzed, version)
+	return []lsp.Diagnostic{{Message: string(rune('0' + version/a.divisor))}}, nil
+}
+
+func (a *analyzeVersion) versions() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]int(nil), a.analyzed...)
+}
+
+const testURI = lsp.DocumentURI("file:///a.swamp")
+
+func TestDiagnosticsManagerDebouncesAndSkipsUnchanged(t *testing.T) {
+	analyzer := &analyzeVersion{divisor: 10}
+	manager := lspserv.NewDiagnosticsManager(20*time.Millisecond, analyzer.analyze)
+	defer manager.Stop()
+	conn := newPublishingConnection()
+
+	manager.Schedule(testURI, 1, conn)
+	manager.Schedule(testURI, 2, conn)
+
+	if params := conn.await(t); params.Version != 2 {
+		t.Errorf("expected only version 2 to be published, got %d", params.Version)
+	}
+	if versions := analyzer.versions(); len(versions) != 1 || versions[0] != 2 {
+		t.Errorf("expected only version 2 to be analyzed, got %v", versions)
+	}
+
+	manager.Schedule(testURI, 3, conn)
+	conn.expectNone(t, 100*time.Millisecond)
+
+	if err := manager.Close(testURI, conn); err != nil {
+		t.Fatal(err)
+	}
+	if params := conn.await(t); len(params.Diagnostics) != 0 {
+		t.Errorf("expected close to clear the diagnostics, got %+v", params)
+	}
+}
+
+func TestDiagnosticsManagerSlowClientDoesNotBlockSchedule(t *testing.T) {
+	analyzer := &analyzeVersion{divisor: 1}
+	manager := lspserv.NewDiagnosticsManager(time.Millisecond, analyzer.analyze)
+	defer manager.Stop()
+	conn := newPublishingConnection()
+	conn.block = make(chan struct{})
+
+	manager.Schedule(testURI, 1, conn)
+	for len(analyzer.versions()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the publish time to start writing.
+	time.Sleep(10 * time.Millisecond)
+
+	var scheduled int32
+	go func() {
+		manager.Schedule(testURI, 2, conn)
+		atomic.StoreInt32(&scheduled, 1)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&scheduled) == 0 {
+		t.Error("Schedule waited for the publish to the slow client")
+	}
+
+	close(conn.block)
+	if params := conn.await(t); params.Version != 1 {
+		t.Errorf("expected version 1 first, got %d", params.Version)
+	}
+	if params := conn.await(t); params.Version != 2 {
+		t.Errorf("expected version 2 next, got %d", params.Version)
+	}
+}
+
+func TestDiagnosticsManagerStop(t *testing.T) {
+	analyzer := &analyzeVersion{divisor: 1}
+	manager := lspserv.NewDiagnosticsManager(20*time.Millisecond, analyzer.analyze)
+	conn := newPublishingConnection()
+
+	manager.Schedule(testURI, 1, conn)
+	manager.Stop()
+
+	conn.expectNone(t, 100*time.Millisecond)
+	if versions := analyzer.versions(); len(versions) != 0 {
+		t.Errorf("expected no analysis after Stop, got %v", versions)
+	}
+}
@@ -0,0 +1,103 @@
+package lspserv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/piot/jsonrpc2"
+)
+
+// Error codes defined by the LSP specification, in addition to the JSON-RPC ones in jsonrpc2.
+const (
+	CodeServerNotInitialized = -32002
+	CodeUnknownErrorCode     = -32001
+	CodeRequestFailed        = -32803
+	CodeServerCancelled      = -32802
+	CodeContentModified      = -32801
+	CodeRequestCancelled     = -32800
+)
+
+// ResponseError is an error that is replied to the client with the given code. Handlers can return it, or wrap
+// it with fmt.Errorf("...: %w", err), to let the client know how to react, e.g. clients silently retry
+// requests that failed with ContentModified.
+type ResponseError struct {
+	Code    int64
+	Message string
+	Data    interface{}
+}
+
+func NewResponseError(code int64, format string, a ...interface{}) *ResponseError {
+	return &ResponseError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// Is reports errors with the same code as equal, so errors.Is(err, ErrContentModified) holds for every
+// ContentModified error.
+func (e *ResponseError) Is(target error) bool {
+	t, ok := target.(*ResponseError)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrServerNotInitialized = &ResponseError{Code: CodeServerNotInitialized, Message: "server not initialized"}
+	ErrRequestFailed        = &ResponseError{Code: CodeRequestFailed, Message: "request failed"}
+	ErrServerCancelled      = &ResponseError{Code: CodeServerCancelled, Message: "server cancelled the request"}
+	ErrContentModified      = &ResponseError{Code: CodeContentModified, Message: "content modified"}
+	ErrRequestCancelled     = &ResponseError{Code: CodeRequestCancelled, Message: "request cancelled"}
+)
+
+// ContentModified reports that the document changed while the request was computed, so the result is outdated.
+func ContentModified(format string, a ...interface{}) error {
+	return NewResponseError(CodeContentModified, format, a...)
+}
+
+// RequestFailed reports a request that was valid, but could not be completed, e.g. a rename of a symbol in a
+// read-only file. Clients show the message to the user.
+func RequestFailed(format string, a ...interface{}) error {
+	return NewResponseError(CodeRequestFailed, format, a...)
+}
+
+// ServerCancelled reports a request that the server stopped working on, e.g. since a newer request replaced it.
+func ServerCancelled(format string, a ...interface{}) error {
+	return NewResponseError(CodeServerCancelled, format, a...)
+}
+
+// toJSONRPCError converts an error returned from a handler to the error replied to the client. Errors that are
+// not a ResponseError or jsonrpc2.Error keep the code 0 they always had. Data that can not be encoded is logged
+// with the fields of the request and left out of the reply.
+func toJSONRPCError(err error, logger Logger, fields []LogField) *jsonrpc2.Error {
+	var rpcErr *jsonrpc2.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+
+	var responseErr *ResponseError
+	if errors.As(err, &responseErr) {
+		e := &jsonrpc2.Error{Code: responseErr.Code, Message: err.Error()}
+		if responseErr.Data != nil {
+			data, marshalErr := json.Marshal(responseErr.Data)
+			if marshalErr != nil {
+				logger.Log(LogError, "HandleLspRequests: replying without the error data", append(fields, Field("error", marshalErr))...)
+			} else {
+				e.Data = (*json.RawMessage)(&data)
+			}
+		}
+		return e
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return &jsonrpc2.Error{Code: CodeRequestCancelled, Message: err.Error()}
+	}
+
+	return &jsonrpc2.Error{Message: err.Error()}
+}
+
+// isExpectedError reports errors that are part of normal operation and not worth logging as errors.
+func isExpectedError(err error) bool {
+	return errors.Is(err, ErrContentModified) || errors.Is(err, ErrRequestCancelled) || errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,55 @@
+package lspserv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+func TestResponseErrorData(t *testing.T) {
+	logger := &recordingLogger{}
+	service := lspserv.NewService(&stubHandler{})
+	service.SetLogger(logger)
+	service.RegisterRequest("swamp/fail", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var data interface{}
+		if err := json.Unmarshal(params, &data); err != nil {
+			return nil, err
+		}
+		if data == "unencodable" {
+			data = make(chan int)
+		}
+
+		return nil, &lspserv.ResponseError{Code: lspserv.CodeRequestFailed, Message: "failed", Data: data}
+	})
+
+	client := lspservtest.NewServiceClient(service)
+	defer client.Close()
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rpcErr *jsonrpc2.Error
+
+	err := client.Call("swamp/fail", map[string]string{"file": "main.swamp"}, nil)
+	expectCode(t, err, lspserv.CodeRequestFailed)
+	if !errors.As(err, &rpcErr) || rpcErr.Data == nil || string(*rpcErr.Data) != `{"file":"main.swamp"}` {
+		t.Errorf("expected the data to be replied, got %v", err)
+	}
+
+	err = client.Call("swamp/fail", "unencodable", nil)
+	expectCode(t, err, lspserv.CodeRequestFailed)
+	if !errors.As(err, &rpcErr) || rpcErr.Data != nil {
+		t.Errorf("expected the reply without data, got %v", err)
+	}
+	if logger.find("HandleLspRequests: replying without the error data", "swamp/fail") == nil {
+		t.Error("expected the data that can not be encoded to be logged")
+	}
+}
@@ -0,0 +1,179 @@
+package lspserv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/piot/jsonrpc2"
+)
+
+// ErrMethodNotFound is returned from HandleUnknownRequest for methods the handler does not serve either.
+var ErrMethodNotFound = errors.New("method not found")
+
+// UnknownRequestHandler can optionally be implemented by a Handler to serve custom extension requests,
+// e.g. `ourlang/showAST`.
+type UnknownRequestHandler interface {
+	HandleUnknownRequest(method string, params json.RawMessage, conn Connection) (interface{}, error)
+}
+
+// UnknownNotificationHandler can optionally be implemented by a Handler to receive notifications that the
+// dispatcher does not know, including `$/` notifications. Without it they are dropped.
+type UnknownNotificationHandler interface {
+	HandleUnknownNotification(method string, params json.RawMessage, conn Connection) error
+}
+
+func (h *HandleLspRequests) handleUnknownMethod(ctx context.Context, req *jsonrpc2.Request, conn Connection) (interface{}, error) {
+	params := rawParams(req)
+
+	ctx = context.WithValue(ctx, connectionContextKey{}, conn)
+	ctx = context.WithValue(ctx, decodeContextKey{}, decodeOptions{method: req.Method, strict: h.strictParams})
+
+	if req.Notif {
+		if fn, ok := h.registeredNotifications[req.Method]; ok {
+			return nil, fn(ctx, params)
+		}
+
+		if notificationHandler, ok := h.handler.(UnknownNotificationHandler); ok {
+			return nil, notificationHandler.HandleUnknownNotification(req.Method, params, conn)
+		}
+
+		h.logger.Log(LogDebug, "HandleLspRequests: ignoring unknown notification", Field("method", req.Method))
+
+		return nil, nil
+	}
+
+	if fn, ok := h.registeredRequests[req.Method]; ok {
+		return fn(ctx, params)
+	}
+
+	if requestHandler, ok := h.handler.(UnknownRequestHandler); ok {
+		result, err := requestHandler.HandleUnknownRequest(req.Method, params, conn)
+		if !errors.Is(err, ErrMethodNotFound) {
+			return result, err
+		}
+	}
+
+	return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("HandleLspRequests: request is not supported: %s", req.Method)}
+}
+
+// RequestFunc serves a custom request registered with RegisterRequest. The Connection is available through
+// ConnectionFromContext.
+type RequestFunc func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// NotificationFunc receives a custom notification registered with RegisterNotification.
+type NotificationFunc func(ctx context.Context, params json.RawMessage) error
+
+// RegisterRequest serves a custom request, e.g. `swamp/expandMacro`. Methods of the protocol itself can not be
+// overridden. Must be called before RunUntilClose.
+func (h *HandleLspRequests) RegisterRequest(method string, fn RequestFunc) {
+	h.registeredRequests[method] = fn
+}
+
+// RegisterNotification receives a custom notification. Must be called before RunUntilClose.
+func (h *HandleLspRequests) RegisterNotification(method string, fn NotificationFunc) {
+	h.registeredNotifications[method] = fn
+}
+
+type connectionContextKey struct{}
+
+// ConnectionFromContext returns the Connection of the request that a RequestFunc or NotificationFunc is called for.
+func ConnectionFromContext(ctx context.Context) Connection {
+	conn, _ := ctx.Value(connectionContextKey{}).(Connection)
+	return conn
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// TypedRequest adapts fn with the signature `func(ctx context.Context, params T) (R, error)` to a RequestFunc,
+// which decodes the params into a T before calling fn. Omitted params are passed as the zero T. It panics if fn
+// has another signature.
+func TypedRequest(fn interface{}) RequestFunc {
+	fnValue, paramTypes := typedFunc(fn, 1, 2)
+
+	return func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		paramsValue, err := decodeTypedParams(ctx, params, paramTypes[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return callTypedFunc(fnValue, []reflect.Value{reflect.ValueOf(ctx), paramsValue})
+	}
+}
+
+// TypedNotification adapts fn with the signature `func(ctx context.Context, params T) error` to a NotificationFunc,
+// which decodes the params into a T before calling fn. Omitted params are passed as the zero T. It panics if fn
+// has another signature.
+func TypedNotification(fn interface{}) NotificationFunc {
+	fnValue, paramTypes := typedFunc(fn, 1, 1)
+
+	return func(ctx context.Context, params json.RawMessage) error {
+		paramsValue, err := decodeTypedParams(ctx, params, paramTypes[0])
+		if err != nil {
+			return err
+		}
+
+		_, err = callTypedFunc(fnValue, []reflect.Value{reflect.ValueOf(ctx), paramsValue})
+		return err
+	}
+}
+
+// typedFunc checks that fn is a func with a context.Context followed by paramCount parameters, or any number of
+// parameters if paramCount is -1, and resultCount results, the last one an error. It returns the types of the
+// parameters after the context.
+func typedFunc(fn interface{}, paramCount int, resultCount int) (reflect.Value, []reflect.Type) {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+
+	if fnType.Kind() != reflect.Func || fnType.NumIn() < 1 || fnType.In(0) != contextType ||
+		(paramCount >= 0 && fnType.NumIn() != paramCount+1) ||
+		fnType.NumOut() != resultCount || fnType.Out(resultCount-1) != errorType {
+		params := "T"
+		if paramCount < 0 {
+			params = "..."
+		}
+		panic(fmt.Sprintf("lspserv: %v must be func(context.Context, %s) with %d results, the last one an error", fnType, params, resultCount))
+	}
+
+	paramTypes := make([]reflect.Type, fnType.NumIn()-1)
+	for index := range paramTypes {
+		paramTypes[index] = fnType.In(index + 1)
+	}
+
+	return fnValue, paramTypes
+}
+
+// callTypedFunc calls a func checked by typedFunc. The result is nil for a func that only returns an error.
+func callTypedFunc(fnValue reflect.Value, in []reflect.Value) (interface{}, error) {
+	out := fnValue.Call(in)
+	if errValue := out[len(out)-1].Interface(); errValue != nil {
+		return nil, errValue.(error)
+	}
+
+	if len(out) == 1 {
+		return nil, nil
+	}
+
+	return out[0].Interface(), nil
+}
+
+// decodeTypedParams decodes params into a new value of paramsType. Omitted or null params decode to the zero value,
+// since JSON-RPC allows requests and notifications without params.
+func decodeTypedParams(ctx context.Context, params json.RawMessage, paramsType reflect.Type) (reflect.Value, error) {
+	target := reflect.New(paramsType)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return target.Elem(), nil
+	}
+
+	if err := DecodeParams(ctx, params, target.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return target.Elem(), nil
+}
@@ -0,0 +1,176 @@
+package lspserv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+type buildGraphParams struct {
+	Target string `json:"target"`
+}
+
+func newExtensionClient(t *testing.T, service lspserv.Service) *lspservtest.Client {
+	t.Helper()
+
+	client := lspservtest.NewServiceClient(service)
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestRegisterRequest(t *testing.T) {
+	service := lspserv.NewService(&stubHandler{})
+	service.RegisterRequest("swamp/echo", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return params, nil
+	})
+
+	client := newExtensionClient(t, service)
+	defer client.Close()
+
+	var echoed map[string]int
+	if err := client.Call("swamp/echo", map[string]int{"a": 2}, &echoed); err != nil {
+		t.Fatal(err)
+	}
+	if echoed["a"] != 2 {
+		t.Errorf("expected the raw params to be echoed, got %v", echoed)
+	}
+
+	err := client.Call("swamp/unknown", nil, nil)
+	expectCode(t, err, jsonrpc2.CodeMethodNotFound)
+}
+
+func TestRegisterNotification(t *testing.T) {
+	received := make(chan json.RawMessage, 1)
+
+	service := lspserv.NewService(&stubHandler{})
+	service.RegisterNotification("swamp/rebuild", func(ctx context.Context, params json.RawMessage) error {
+		received <- params
+		return nil
+	})
+
+	client := newExtensionClient(t, service)
+	defer client.Close()
+
+	if err := client.Notify("swamp/rebuild", map[string]bool{"clean": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case params := <-received:
+		if string(params) != `{"clean":true}` {
+			t.Errorf("unexpected params %s", params)
+		}
+	case <-time.After(lspservtest.DefaultTimeout):
+		t.Fatal("the notification was not received")
+	}
+}
+
+func TestTypedRequest(t *testing.T) {
+	service := lspserv.NewService(&stubHandler{})
+	service.RegisterRequest("swamp/buildGraph", lspserv.TypedRequest(func(ctx context.Context, params buildGraphParams) ([]string, error) {
+		if params.Target == "" {
+			return []string{"all"}, nil
+		}
+		return []string{params.Target}, nil
+	}))
+
+	client := newExtensionClient(t, service)
+	defer client.Close()
+
+	for _, test := range []struct {
+		name     string
+		params   interface{}
+		expected string
+	}{
+		{"typed params", buildGraphParams{Target: "main"}, "main"},
+		{"omitted params", nil, "all"},
+		{"null params", json.RawMessage("null"), "all"},
+	} {
+		var graph []string
+		if err := client.Call("swamp/buildGraph", test.params, &graph); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(graph) != 1 || graph[0] != test.expected {
+			t.Errorf("%s: expected [%s], got %v", test.name, test.expected, graph)
+		}
+	}
+
+	err := client.Call("swamp/buildGraph", json.RawMessage(`{"target":5}`), nil)
+	expectCode(t, err, jsonrpc2.CodeInvalidParams)
+}
+
+func TestTypedNotification(t *testing.T) {
+	received := make(chan buildGraphParams, 2)
+
+	service := lspserv.NewService(&stubHandler{})
+	service.RegisterNotification("swamp/rebuild", lspserv.TypedNotification(func(ctx context.Context, params buildGraphParams) error {
+		received <- params
+		return nil
+	}))
+
+	client := newExtensionClient(t, service)
+	defer client.Close()
+
+	if err := client.Notify("swamp/rebuild", buildGraphParams{Target: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Notify("swamp/rebuild", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"main", ""} {
+		select {
+		case params := <-received:
+			if params.Target != expected {
+				t.Errorf("expected target %q, got %+v", expected, params)
+			}
+		case <-time.After(lspservtest.DefaultTimeout):
+			t.Fatalf("the notification with target %q was not received", expected)
+		}
+	}
+}
+
+func TestConnectionFromContext(t *testing.T) {
+	const uri = lsp.DocumentURI("file:///main.swamp")
+
+	service := lspserv.NewService(&stubHandler{})
+	service.RegisterRequest("swamp/check", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		conn := lspserv.ConnectionFromContext(ctx)
+		if conn == nil {
+			return nil, errors.New("no connection in the context")
+		}
+
+		return nil, conn.PublishDiagnostics(lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: []lsp.Diagnostic{{Message: "checked"}}})
+	})
+
+	client := newExtensionClient(t, service)
+	defer client.Close()
+
+	if err := client.Call("swamp/check", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics, err := client.AwaitDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics.Diagnostics) != 1 || diagnostics.Diagnostics[0].Message != "checked" {
+		t.Errorf("unexpected diagnostics %+v", diagnostics)
+	}
+
+	if conn := lspserv.ConnectionFromContext(context.Background()); conn != nil {
+		t.Errorf("expected no connection outside of a request, got %v", conn)
+	}
+}
@@ -0,0 +1,205 @@
+package lspserv
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/piot/go-lsp"
+)
+
+type FoldingRangeKind string
+
+const (
+	FoldingRangeComment FoldingRangeKind = "comment"
+	FoldingRangeImports FoldingRangeKind = "imports"
+	FoldingRangeRegion  FoldingRangeKind = "region"
+)
+
+type FoldingRangeParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+}
+
+// FoldingRange is a range of lines that the client can collapse. The characters are optional and are removed
+// for clients that only fold whole lines.
+type FoldingRange struct {
+	StartLine      int              `json:"startLine"`
+	StartCharacter *int             `json:"startCharacter,omitempty"`
+	EndLine        int              `json:"endLine"`
+	EndCharacter   *int             `json:"endCharacter,omitempty"`
+	Kind           FoldingRangeKind `json:"kind,omitempty"`
+	CollapsedText  string           `json:"collapsedText,omitempty"`
+}
+
+// FoldingRangeHandler can optionally be implemented by a Handler to serve `textDocument/foldingRange`.
+// Handlers without a parser can return FoldingRangesFromIndentation or FoldingRangesFromBrackets.
+type FoldingRangeHandler interface {
+	HandleFoldingRange(params FoldingRangeParams, conn Connection) ([]FoldingRange, error)
+}
+
+func (h *HandleLspRequests) foldingRangeProvider() *lsp.FoldingRangeOptions {
+	if _, ok := h.handler.(FoldingRangeHandler); !ok {
+		return nil
+	}
+
+	return &lsp.FoldingRangeOptions{}
+}
+
+// limitFoldingRanges applies the `rangeLimit` and `lineFoldingOnly` capabilities of the client. When there are
+// too many ranges, the ones starting first in the document are kept.
+func (h *HandleLspRequests) limitFoldingRanges(ranges []FoldingRange) []FoldingRange {
+	capabilities := h.clientCapabilities.TextDocument.FoldingRange
+
+	limited := make([]FoldingRange, 0, len(ranges))
+	for _, r := range ranges {
+		if capabilities.LineFoldingOnly {
+			r.StartCharacter = nil
+			r.EndCharacter = nil
+			if r.EndLine <= r.StartLine {
+				continue
+			}
+		}
+		limited = append(limited, r)
+	}
+
+	if capabilities.RangeLimit > 0 && len(limited) > capabilities.RangeLimit {
+		sort.SliceStable(limited, func(a, b int) bool {
+			return limited[a].StartLine < limited[b].StartLine
+		})
+		limited = limited[:capabilities.RangeLimit]
+	}
+
+	return limited
+}
+
+// FoldingRangesFromIndentation folds every line followed by lines that are indented deeper, like
+// Python or YAML. Tabs count as tabSize columns and blank lines belong to the block around them.
+func FoldingRangesFromIndentation(text string, tabSize int) []FoldingRange {
+	if tabSize <= 0 {
+		tabSize = 1
+	}
+
+	type open struct {
+		line   int
+		indent int
+	}
+
+	ranges := []FoldingRange{}
+	var stack []open
+	lastNonBlank := -1
+
+	closeBlocks := func(indent int) {
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if lastNonBlank > top.line {
+				ranges = append(ranges, FoldingRange{StartLine: top.line, EndLine: lastNonBlank})
+			}
+		}
+	}
+
+	for lineIndex, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		indent := indentation(line, tabSize)
+		closeBlocks(indent)
+		stack = append(stack, open{line: lineIndex, indent: indent})
+		lastNonBlank = lineIndex
+	}
+	closeBlocks(-1)
+
+	sortFoldingRanges(ranges)
+
+	return ranges
+}
+
+func indentation(line string, tabSize int) int {
+	columns := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			columns++
+		case '\t':
+			columns += tabSize - columns%tabSize
+		default:
+			return columns
+		}
+	}
+	return columns
+}
+
+// FoldingRangesFromBrackets folds the lines between matching brackets that span several lines, e.g.
+// `{}`, `[]` and `()` when pairs is empty. Every pair is a string of the open and the close character.
+// The range ends on the line before the closing bracket, so it stays visible when folded. Brackets in strings
+// and comments are not skipped.
+func FoldingRangesFromBrackets(text string, pairs ...string) []FoldingRange {
+	if len(pairs) == 0 {
+		pairs = []string{"{}", "[]", "()"}
+	}
+
+	closing := make(map[rune]rune)
+	opening := make(map[rune]bool)
+	for _, pair := range pairs {
+		runes := []rune(pair)
+		if len(runes) != 2 {
+			continue
+		}
+		opening[runes[0]] = true
+		closing[runes[1]] = runes[0]
+	}
+
+	type open struct {
+		bracket   rune
+		line      int
+		character int
+	}
+
+	ranges := []FoldingRange{}
+	var stack []open
+
+	for lineIndex, line := range strings.Split(text, "\n") {
+		character := 0
+		for _, r := range line {
+			if opening[r] {
+				stack = append(stack, open{bracket: r, line: lineIndex, character: character})
+			} else if openBracket, isClosing := closing[r]; isClosing {
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i].bracket != openBracket {
+						continue
+					}
+					start := stack[i]
+					stack = stack[:i]
+					if lineIndex-1 > start.line {
+						startCharacter := start.character + 1
+						ranges = append(ranges, FoldingRange{StartLine: start.line, StartCharacter: &startCharacter, EndLine: lineIndex - 1})
+					}
+					break
+				}
+			}
+			character += utf16Length(r)
+		}
+	}
+
+	sortFoldingRanges(ranges)
+
+	return ranges
+}
+
+// utf16Length is the number of UTF-16 code units of r, which LSP positions count by default.
+func utf16Length(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func sortFoldingRanges(ranges []FoldingRange) {
+	sort.SliceStable(ranges, func(a, b int) bool {
+		if ranges[a].StartLine != ranges[b].StartLine {
+			return ranges[a].StartLine < ranges[b].StartLine
+		}
+		return ranges[a].EndLine > ranges[b].EndLine
+	})
+}
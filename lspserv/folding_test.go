@@ -0,0 +1,67 @@
+package lspserv_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+// formatFoldingRanges writes each range as `start-end`, with `:character` after the start line if it is set.
+func formatFoldingRanges(ranges []lspserv.FoldingRange) []string {
+	formatted := []string{}
+	for _, r := range ranges {
+		start := fmt.Sprint(r.StartLine)
+		if r.StartCharacter != nil {
+			start = fmt.Sprintf("%d:%d", r.StartLine, *r.StartCharacter)
+		}
+		formatted = append(formatted, fmt.Sprintf("%s-%d", start, r.EndLine))
+	}
+	return formatted
+}
+
+func TestFoldingRangesFromIndentation(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		text     string
+		tabSize  int
+		expected []string
+	}{
+		{"flat", "a\nb\nc", 4, []string{}},
+		{"block", "a:\n  b\n  c\nd", 4, []string{"0-2"}},
+		{"nested", "a:\n  b:\n    c\n  d\ne", 4, []string{"0-3", "1-2"}},
+		{"block at the end", "a:\n  b\n  c", 4, []string{"0-2"}},
+		{"blank lines inside", "a:\n  b\n\n  c\nd", 4, []string{"0-3"}},
+		{"trailing blank lines", "a:\n  b\n\n\nd", 4, []string{"0-1"}},
+		{"tabs", "a:\n\tb\n    c\nd", 4, []string{"0-2"}},
+		{"crlf", "a:\r\n  b\r\nc", 4, []string{"0-1"}},
+	} {
+		actual := formatFoldingRanges(lspserv.FoldingRangesFromIndentation(test.text, test.tabSize))
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestFoldingRangesFromBrackets(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		text     string
+		pairs    []string
+		expected []string
+	}{
+		{"single line", "f(a, b) { return }", nil, []string{}},
+		{"two lines", "{\n}", nil, []string{}},
+		{"block", "func f() {\n  a\n}", nil, []string{"0:10-1"}},
+		{"nested", "{\n  [\n    1,\n  ]\n}", nil, []string{"0:1-3", "1:3-2"}},
+		{"unbalanced close", "{\n  )\n  a\n}", nil, []string{"0:1-2"}},
+		{"custom pairs", "begin <\n  a\n>\n{\n  b\n}", []string{"<>"}, []string{"0:7-1"}},
+		{"utf-16 characters", "\U0001F600 {\n  a\n}", nil, []string{"0:4-1"}},
+	} {
+		actual := formatFoldingRanges(lspserv.FoldingRangesFromBrackets(test.text, test.pairs...))
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -3,16 +3,25 @@ package lspserv
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/piot/go-lsp"
 	"github.com/piot/jsonrpc2"
 )
 
 type Connection interface {
+	// Context is cancelled when the request has exceeded its timeout. `$/cancelRequest` does not cancel it, since
+	// requests are handled one at a time and a cancellation is only read after the request has been answered.
+	Context() context.Context
 	PublishDiagnostics(params lsp.PublishDiagnosticsParams) error
+	// LogTrace emits `$/logTrace` when permitted by the trace level set by the client.
+	LogTrace(message string, verbose string) error
+	// RequestInlayHintRefresh asks the client to request the inlay hints again, without waiting for the reply.
+	RequestInlayHintRefresh() error
+	// SemanticTokensBuilder encodes tokens against the legend of the handler. The text is only needed for tokens
+	// spanning several lines.
+	SemanticTokensBuilder(text string) *SemanticTokensBuilder
 	//RequestCodeLensRefresh() error
 }
 
@@ -39,12 +48,6 @@ type Handler interface {
 	HandleRename(params lsp.RenameParams) (*lsp.WorkspaceEdit, error)
 	HandleSemanticTokensFull(params lsp.SemanticTokensParams, conn Connection) (*lsp.SemanticTokens, error)
 	//HandlePrepareRename(params lsp.PrepareRenameParams) (*lsp.PrepareRenameResult, error)
-	//HandleFoldingRange(params lsp.FoldingRangeParams) ([]*lsp.FoldingRange, error)
-	//HandleSelectionRange(params lsp.SelectionRangeParams) ([]*lsp.SelectionRange, error)
-
-	//HandlePrepareCallHierarchy(params lsp.CallHierarchyPrepareParams) ([]*lsp.CallHierarchyItem, error)
-	//HandleCallHierarchyIncomingCalls(params lsp.CallHierarchyIncomingCallsParams, conn Connection) ([]*CallHierarchyIncomingCall, error)
-	//HandleCallHierarchyOutgoingCalls(params lsp.CallHierarchyOutgoingCallsParams, conn Connection) ([]*CallHierarchyOutgoingCall, error)
 	//HandleSemanticTokens(params lsp.SemanticTokensParams) (*lsp.SemanticTokens, error)
 
 	//HandleMonikers()
@@ -75,25 +78,96 @@ type Handler interface {
 }
 
 type SendOut struct {
-	conn jsonrpc2.JSONRPC2
-	ctx  context.Context
+	conn     jsonrpc2.JSONRPC2
+	ctx      context.Context
+	requests *HandleLspRequests
 }
 
 func NewSendOut(conn jsonrpc2.JSONRPC2, ctx context.Context) *SendOut {
 	return &SendOut{conn: conn, ctx: ctx}
 }
 
+func (s *SendOut) Context() context.Context {
+	return s.ctx
+}
+
 func (s *SendOut) PublishDiagnostics(params lsp.PublishDiagnosticsParams) error {
 	return s.conn.Notify(s.ctx, "textDocument/publishDiagnostics", params)
 }
 
+// dispatchRequest sends a request to the client without waiting for the reply. Requests are handled on the
+// goroutine reading the connection, so waiting for the reply inside a handler would never return.
+func (s *SendOut) dispatchRequest(method string, params interface{}) error {
+	conn, ok := s.conn.(*jsonrpc2.Conn)
+	if !ok {
+		return fmt.Errorf("SendOut: can not send %s without a connection", method)
+	}
+
+	_, err := conn.DispatchCall(context.Background(), method, params)
+	return err
+}
+
+// timeoutResults are the empty results replied to latency sensitive requests that time out,
+// so the client shows nothing instead of an error.
+var timeoutResults = map[string]interface{}{
+	"textDocument/completion":        &lsp.CompletionList{IsIncomplete: true, Items: []lsp.CompletionItem{}},
+	"textDocument/hover":             nil,
+	"textDocument/signatureHelp":     nil,
+	"textDocument/documentHighlight": []*lsp.DocumentHighlight{},
+}
+
 type HandleLspRequests struct {
-	handler       Handler
-	isInitialized bool
+	handler              Handler
+	lifecycle            lifecycle
+	requestTimeouts      map[string]time.Duration
+	slowRequestThreshold time.Duration
+	interceptors         []Interceptor
+	logger               Logger
+	trace                traceLevel
+	strictParams         bool
+	symbolIndex          *SymbolIndex
+	clientCapabilities   clientCapabilities
+	semanticTokensLegend *semanticTokensLegend
+	semanticTokensCache  semanticTokensCache
+	diagnostics          *DiagnosticsManager
+	// abandoned is closed when the handler of the last timed out request returns.
+	abandoned chan struct{}
+
+	registeredRequests      map[string]RequestFunc
+	registeredNotifications map[string]NotificationFunc
+	commands                map[string]CommandFunc
 }
 
 func NewLspRequests(handler Handler) *HandleLspRequests {
-	return &HandleLspRequests{handler: handler}
+	return &HandleLspRequests{
+		handler:                 handler,
+		requestTimeouts:         make(map[string]time.Duration),
+		logger:                  defaultLogger,
+		registeredRequests:      make(map[string]RequestFunc),
+		registeredNotifications: make(map[string]NotificationFunc),
+		commands:                make(map[string]CommandFunc),
+	}
+}
+
+// SetLogger replaces the logger, which defaults to info level text on stderr.
+func (h *HandleLspRequests) SetLogger(logger Logger) {
+	h.logger = logger
+}
+
+// SetRequestTimeout sets the maximum time a request of the given method is allowed to run.
+// A timeout of zero removes the deadline.
+func (h *HandleLspRequests) SetRequestTimeout(method string, timeout time.Duration) {
+	if timeout <= 0 {
+		delete(h.requestTimeouts, method)
+		return
+	}
+	h.requestTimeouts[method] = timeout
+}
+
+// SetSlowRequestThreshold logs the elapsed time of every request that runs longer than threshold.
+// A threshold of zero disables the diagnostics.
+func (h *HandleLspRequests) SetSlowRequestThreshold(threshold time.Duration) {
+	h.slowRequestThreshold = threshold
 }
 
 func isFileSystemRequest(method string) bool {
@@ -105,13 +179,24 @@ func isFileSystemRequest(method string) bool {
 }
 
 func (h *HandleLspRequests) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
-	result, err := h.HandleInternal(ctx, conn, req)
-	if err != nil {
-		log.Printf("error: %v\n", err)
+	h.awaitAbandoned()
+
+	info := &requestInfo{}
+	ctx = context.WithValue(ctx, requestInfoContextKey{}, info)
+
+	start := time.Now()
+	result, err := h.handleWithTimeout(ctx, conn, req)
+	elapsed := time.Since(start)
+
+	fields := requestLogFields(req, elapsed, info.documentURI())
+	if h.slowRequestThreshold > 0 && elapsed > h.slowRequestThreshold {
+		h.logger.Log(LogWarn, "HandleLspRequests: slow request", fields...)
 	}
 	if req.Notif {
 		if err != nil {
-			log.Printf("HandleLspRequests: notification %q error: %v\n", req.Method, err)
+			h.logger.Log(LogError, "HandleLspRequests: notification error", append(fields, Field("error", err))...)
+		} else {
+			h.logger.Log(LogDebug, "HandleLspRequests: notification handled", fields...)
 		}
 		return
 	}
@@ -123,252 +208,343 @@ func (h *HandleLspRequests) Handle(ctx context.Context, conn *jsonrpc2.Conn, req
 	}
 
 	if err != nil {
-		log.Printf("HandleLspRequests: json response error %v\n", err)
-
-		if e, ok := err.(*jsonrpc2.Error); ok {
-			resp.Error = e
-		} else {
-			resp.Error = &jsonrpc2.Error{Message: err.Error()}
+		level := LogError
+		if isExpectedError(err) {
+			level = LogDebug
 		}
+		h.logger.Log(level, "HandleLspRequests: request error", append(fields, Field("error", err))...)
+
+		resp.Error = toJSONRPCError(err, h.logger, fields)
+	} else {
+		h.logger.Log(LogDebug, "HandleLspRequests: request handled", fields...)
 	}
 
 	if !req.Notif {
 		if err := conn.SendResponse(ctx, resp); err != nil {
 			if err != jsonrpc2.ErrClosed {
-				log.Printf("HandleLspRequests: sending response %s: %v\n", resp.ID, err)
+				h.logger.Log(LogError, "HandleLspRequests: sending response failed", append(fields, Field("error", err))...)
 			}
 		}
 	}
 }
 
-func (h *HandleLspRequests) handleFileSystemRequest(ctx context.Context, req *jsonrpc2.Request, conn Connection) error {
+// requestLogFields describes the request with the method, request ID, duration and the document URI, if any.
+func requestLogFields(req *jsonrpc2.Request, elapsed time.Duration, uri lsp.DocumentURI) []LogField {
+	fields := []LogField{Field("method", req.Method)}
+	if !req.Notif {
+		fields = append(fields, Field("id", req.ID))
+	}
+	fields = append(fields, Field("duration", elapsed))
+
+	if uri != "" {
+		fields = append(fields, Field("uri", uri))
+	}
+
+	return fields
+}
+
+// handleWithTimeout runs the request with the deadline set for its method. When the deadline
+// expires the handler context is cancelled and the reply is sent without waiting for the handler,
+// which keeps running in the background until it returns. The next message is only dispatched
+// after that, so the Handler is never called concurrently.
+func (h *HandleLspRequests) handleWithTimeout(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	timeout, hasTimeout := h.requestTimeouts[req.Method]
+	if !hasTimeout || req.Notif {
+		return h.HandleInternal(ctx, conn, req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type handled struct {
+		result interface{}
+		err    error
+	}
+
+	done := make(chan handled, 1)
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		result, err := h.HandleInternal(ctx, conn, req)
+		done <- handled{result: result, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.result, r.err
+	case <-ctx.Done():
+		h.abandoned = returned
+		h.logger.Log(LogWarn, "HandleLspRequests: request timed out", Field("method", req.Method), Field("id", req.ID), Field("timeout", timeout))
+		if result, isLatencySensitive := timeoutResults[req.Method]; isLatencySensitive {
+			return result, nil
+		}
+		return nil, ServerCancelled("HandleLspRequests: request %s timed out after %v", req.Method, timeout)
+	}
+}
+
+// awaitAbandoned waits for the handler of a timed out request to return. Handlers are expected to return soon
+// after their context is cancelled.
+func (h *HandleLspRequests) awaitAbandoned() {
+	if h.abandoned == nil {
+		return
+	}
+
+	select {
+	case <-h.abandoned:
+	default:
+		h.logger.Log(LogDebug, "HandleLspRequests: waiting for the handler of a timed out request")
+		<-h.abandoned
+	}
+	h.abandoned = nil
+}
+
+func (h *HandleLspRequests) handleFileSystemRequest(ctx context.Context, req *jsonrpc2.Request, conn Connection) (interface{}, error) {
 	switch req.Method {
 	case "textDocument/didOpen":
 		var params lsp.DidOpenTextDocumentParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return err
-		}
-		return h.handler.HandleDidOpen(params, conn)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			if err := h.handler.HandleDidOpen(params, conn); err != nil {
+				return nil, err
+			}
+			if h.diagnostics != nil {
+				h.diagnostics.Schedule(params.TextDocument.URI, params.TextDocument.Version, conn)
+			}
+
+			return nil, nil
+		})
 
 	case "textDocument/didChange":
 		var params lsp.DidChangeTextDocumentParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return err
-		}
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			if err := h.handler.HandleDidChange(params, conn); err != nil {
+				return nil, err
+			}
+			if h.diagnostics != nil {
+				h.diagnostics.Schedule(params.TextDocument.URI, params.TextDocument.Version, conn)
+			}
 
-		return h.handler.HandleDidChange(params, conn)
+			return nil, nil
+		})
 
 	case "textDocument/didClose":
 		var params lsp.DidCloseTextDocumentParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return err
-		}
-		return h.handler.HandleDidClose(params, conn)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			if err := h.handler.HandleDidClose(params, conn); err != nil {
+				return nil, err
+			}
+			h.semanticTokensCache.remove(params.TextDocument.URI)
+			if h.diagnostics != nil {
+				return nil, h.diagnostics.Close(params.TextDocument.URI, conn)
+			}
+
+			return nil, nil
+		})
 
 	case "textDocument/willSave":
 		var params lsp.WillSaveTextDocumentParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return err
-		}
-		return h.handler.HandleWillSave(params, conn)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return nil, h.handler.HandleWillSave(params, conn)
+		})
 
 	case "textDocument/didSave":
 		var params lsp.DidSaveTextDocumentParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return err
-		}
-		return h.handler.HandleDidSave(params, conn)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return nil, h.handler.HandleDidSave(params, conn)
+		})
 
 	default:
-		return fmt.Errorf("HandleLspRequests: unexpected file system request %v ", req.Method)
+		return nil, fmt.Errorf("HandleLspRequests: unexpected file system request %v ", req.Method)
 	}
 }
 
-func (h *HandleLspRequests) HandleInternal(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request) (result interface{}, err error) {
-	if req.Method != "initialize" && !h.isInitialized {
-		return nil, errors.New("HandleLspRequests: language server must be initialized, before issuing any other commands")
+func (h *HandleLspRequests) initialize(req *jsonrpc2.Request) (interface{}, error) {
+	if h.lifecycle.get() != stateUninitialized {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: "HandleLspRequests: language server has already been initialized"}
 	}
 
-	out := NewSendOut(conn, ctx)
+	// Only some of the params are read here, so the unknown fields are always allowed.
+	var params struct {
+		Trace        lsp.Trace          `json:"trace"`
+		Capabilities clientCapabilities `json:"capabilities"`
+	}
 
-	switch req.Method {
-	case "initialize":
-		if h.isInitialized {
-			return nil, errors.New("HandleLspRequests: language server has already been initialized")
-		}
+	if err := decodeParams(req.Method, rawParams(req), &params, false); err != nil {
+		return nil, err
+	}
 
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Data: nil, Message: ""}
-		}
+	if err := h.trace.Set(params.Trace); err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+	}
 
-		if err := h.handler.Reset(); err != nil {
-			return nil, fmt.Errorf("reset failed %w", err)
-		}
+	h.clientCapabilities = params.Capabilities
 
-		h.isInitialized = true
-
-		kind := lsp.TDSKIncremental
-
-		tokenTypes := []string{
-			"namespace",
-			"type",
-			"class",
-			"enum",
-			"interface",
-			"struct",
-			"typeParameter",
-			"parameter",
-			"variable",
-			"property",
-			"enumMember",
-			"event",
-			"function",
-			"method",
-			"macro",
-			"keyword",
-			"modifier",
-			"comment",
-			"string",
-			"number",
-			"regexp",
-			"operator",
-		}
-		tokenModifiers := []string{
-			"declaration",
-			"definition",
-			"readonly",
-			"static",
-			"deprecated",
-			"abstract",
-			"async",
-			"modification",
-			"documentation",
-			"defaultLibrary",
-		}
-		syncOptions := lsp.TextDocumentSyncOptions{
-			OpenClose:         true,
-			Change:            kind,
-			WillSave:          true,
-			WillSaveWaitUntil: false,
-			Save:              &lsp.SaveOptions{IncludeText: true},
-		}
+	semanticTokensCapabilities := params.Capabilities.TextDocument.SemanticTokens
+	semanticTokensLegend, err := newSemanticTokensLegend(h.handlerSemanticTokensLegend(), semanticTokensCapabilities.TokenTypes, semanticTokensCapabilities.TokenModifiers)
+	if err != nil {
+		return nil, err
+	}
+	h.semanticTokensLegend = semanticTokensLegend
 
-		return lsp.InitializeResult{
-			Capabilities: lsp.ServerCapabilities{
-				TextDocumentSync:       &lsp.TextDocumentSyncOptionsOrKind{Options: &syncOptions, Kind: nil},
-				CompletionProvider:     &lsp.CompletionOptions{ResolveProvider: false, TriggerCharacters: []string{"."}},
-				HoverProvider:          true,
-				SignatureHelpProvider:  &lsp.SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
-				DeclarationProvider:    nil,
-				DefinitionProvider:     true,
-				TypeDefinitionProvider: true,
-				ImplementationProvider: &lsp.ImplementationOptions{},
-				ReferencesProvider: &lsp.ReferenceOptions{
-					WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
-						WorkDoneProgress: false,
-					},
+	if err := h.handler.Reset(); err != nil {
+		return nil, fmt.Errorf("reset failed %w", err)
+	}
+
+	h.lifecycle.set(stateInitialized)
+
+	kind := lsp.TDSKIncremental
+
+	syncOptions := lsp.TextDocumentSyncOptions{
+		OpenClose:         true,
+		Change:            kind,
+		WillSave:          true,
+		WillSaveWaitUntil: false,
+		Save:              &lsp.SaveOptions{IncludeText: true},
+	}
+
+	capabilities := ServerCapabilities{
+		ServerCapabilities: lsp.ServerCapabilities{
+			TextDocumentSync:       &lsp.TextDocumentSyncOptionsOrKind{Options: &syncOptions, Kind: nil},
+			CompletionProvider:     &lsp.CompletionOptions{ResolveProvider: false, TriggerCharacters: []string{"."}},
+			HoverProvider:          true,
+			SignatureHelpProvider:  &lsp.SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
+			DeclarationProvider:    nil,
+			DefinitionProvider:     true,
+			TypeDefinitionProvider: true,
+			ImplementationProvider: &lsp.ImplementationOptions{},
+			ReferencesProvider: &lsp.ReferenceOptions{
+				WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
+					WorkDoneProgress: false,
 				},
-				DocumentHighlightProvider:       &lsp.DocumentHighlightOptions{},
-				DocumentSymbolProvider:          true,
-				DocumentLinkProvider:            nil, // TODO: Not sure what this is yet.
-				ColorProvider:                   nil,
-				DocumentFormattingProvider:      true,
-				CodeActionProvider:              false,
-				CodeLensProvider:                &lsp.CodeLensOptions{ResolveProvider: false},
-				DocumentRangeFormattingProvider: false,
-				DocumentOnTypeFormattingProvider: &lsp.DocumentOnTypeFormattingOptions{
-					FirstTriggerCharacter: "",
-					MoreTriggerCharacter:  []string{},
+			},
+			DocumentHighlightProvider:        &lsp.DocumentHighlightOptions{},
+			DocumentSymbolProvider:           true,
+			DocumentLinkProvider:             nil, // TODO: Not sure what this is yet.
+			ColorProvider:                    nil,
+			DocumentFormattingProvider:       true,
+			CodeActionProvider:               false,
+			CodeLensProvider:                 &lsp.CodeLensOptions{ResolveProvider: false},
+			DocumentRangeFormattingProvider:  false,
+			DocumentOnTypeFormattingProvider: nil,
+			RenameProvider:                   true,
+			FoldingRangeProvider:             h.foldingRangeProvider(),
+			ExecuteCommandProvider:           h.executeCommandProvider(),
+			SelectionRangeProvider:           h.selectionRangeProvider(),
+			LinkedEditingRangeProvider: &lsp.LinkedEditingRangeOptions{
+				WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
+					WorkDoneProgress: false,
 				},
-				RenameProvider:       true,
-				FoldingRangeProvider: nil,
-				ExecuteCommandProvider: &lsp.ExecuteCommandOptions{
-					Commands: []string{},
+			},
+			CallHierarchyProvider: h.callHierarchyProvider(),
+			SemanticTokensProvider: &lsp.SemanticTokensOptions{
+				WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
+					WorkDoneProgress: false,
 				},
-				SelectionRangeProvider: &lsp.SelectionRangeOptions{
-					WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
-						WorkDoneProgress: false,
-					},
+				Legend: h.semanticTokensLegend.client,
+				Range:  true,
+				Full: &lsp.SemanticTokenOptionsFull{
+					Delta: true,
 				},
-				LinkedEditingRangeProvider: &lsp.LinkedEditingRangeOptions{
-					WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
-						WorkDoneProgress: false,
-					},
+			},
+			MonikerProvider: nil,
+			Workspace: &lsp.WorkspaceOptions{
+				WorkspaceFolders: &lsp.WorkspaceFoldersServerCapabilities{
+					Supported:           false,
+					ChangeNotifications: "",
 				},
-				CallHierarchyProvider: &lsp.CallHierarchyOptions{
-					WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
-						WorkDoneProgress: false,
+				FileOperations: &lsp.WorkspaceOptionsFileOperations{
+					DidCreate: &lsp.FileOperationRegistrationOptions{
+						Filters: []lsp.FileOperationFilter{},
 					},
-				},
-				SemanticTokensProvider: &lsp.SemanticTokensOptions{
-					WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
-						WorkDoneProgress: false,
+					WillCreate: &lsp.FileOperationRegistrationOptions{
+						Filters: []lsp.FileOperationFilter{},
 					},
-					Legend: lsp.SemanticTokensLegend{
-						TokenTypes:     tokenTypes,
-						TokenModifiers: tokenModifiers,
+					DidRename: &lsp.FileOperationRegistrationOptions{
+						Filters: []lsp.FileOperationFilter{},
 					},
-					Range: false,
-					Full: &lsp.SemanticTokenOptionsFull{
-						Delta: false,
+					WillRename: &lsp.FileOperationRegistrationOptions{
+						Filters: []lsp.FileOperationFilter{},
 					},
-				},
-				MonikerProvider: &lsp.MonikerOptions{
-					WorkDoneProgressOptions: lsp.WorkDoneProgressOptions{
-						WorkDoneProgress: false,
+					DidDelete: &lsp.FileOperationRegistrationOptions{
+						Filters: []lsp.FileOperationFilter{},
 					},
-				},
-				WorkspaceSymbolProvider: true,
-				Workspace: &lsp.WorkspaceOptions{
-					WorkspaceFolders: &lsp.WorkspaceFoldersServerCapabilities{
-						Supported:           false,
-						ChangeNotifications: "",
-					},
-					FileOperations: &lsp.WorkspaceOptionsFileOperations{
-						DidCreate: &lsp.FileOperationRegistrationOptions{
-							Filters: []lsp.FileOperationFilter{},
-						},
-						WillCreate: &lsp.FileOperationRegistrationOptions{
-							Filters: []lsp.FileOperationFilter{},
-						},
-						DidRename: &lsp.FileOperationRegistrationOptions{
-							Filters: []lsp.FileOperationFilter{},
-						},
-						WillRename: &lsp.FileOperationRegistrationOptions{
-							Filters: []lsp.FileOperationFilter{},
-						},
-						DidDelete: &lsp.FileOperationRegistrationOptions{
-							Filters: []lsp.FileOperationFilter{},
-						},
-						WillDelete: &lsp.FileOperationRegistrationOptions{
-							Filters: []lsp.FileOperationFilter{},
-						},
+					WillDelete: &lsp.FileOperationRegistrationOptions{
+						Filters: []lsp.FileOperationFilter{},
 					},
 				},
-				Experimental:                 nil,
-				XWorkspaceReferencesProvider: false,
-				XDefinitionProvider:          false,
-				XWorkspaceSymbolByProperties: false,
 			},
-		}, nil
+			Experimental:                 nil,
+			XWorkspaceReferencesProvider: false,
+			XDefinitionProvider:          false,
+			XWorkspaceSymbolByProperties: false,
+		},
+		WorkspaceSymbolProvider: h.workspaceSymbolProvider(),
+		TypeHierarchyProvider:   h.typeHierarchyProvider(),
+		InlayHintProvider:       h.inlayHintProvider(),
+		DiagnosticProvider:      h.diagnosticProvider(),
+	}
+
+	return InitializeResult{Capabilities: capabilities}, nil
+}
+
+// declarationParams are the params of `textDocument/declaration`. lsp.DeclarationOptions, which the Handler receives,
+// has no fields, so strict params would reject every request without the position params.
+type declarationParams struct {
+	lsp.TextDocumentPositionParams
+	lsp.DeclarationOptions
+}
+
+func (h *HandleLspRequests) HandleInternal(ctx context.Context, conn jsonrpc2.JSONRPC2, req *jsonrpc2.Request) (result interface{}, err error) {
+	if admitted, err := h.admit(req); !admitted {
+		return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	out := NewSendOut(conn, ctx)
+	out.requests = h
+
+	switch req.Method {
+	case "initialize":
+		return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+			return h.initialize(req)
+		})
 
 	case "initialized":
 		// A notification that the client is ready to receive requests. TODO: should check client capabilities
-		return nil, nil
+		return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+			return nil, nil
+		})
 
 	case "shutdown":
-		h.handler.ShutDown()
+		return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+			h.handler.ShutDown()
+			h.lifecycle.set(stateShutdown)
 
-		return nil, nil
+			return nil, nil
+		})
 
 	case "exit":
-		if c, ok := conn.(*jsonrpc2.Conn); ok {
-			c.Close()
-		}
+		return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+			h.lifecycle.exit()
+			if c, ok := conn.(*jsonrpc2.Conn); ok {
+				c.Close()
+			}
+
+			return nil, nil
+		})
+
+	case "$/setTrace":
+		var params SetTraceParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			if err := h.trace.Set(params.Value); err != nil {
+				return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+			}
 
-		return nil, nil
+			return nil, nil
+		})
 
 	case "$/cancelRequest":
+		// Requests are handled one at a time, so the cancelled request has already been answered.
 		if req.Params == nil {
 			return nil, nil
 		}
@@ -379,226 +555,311 @@ func (h *HandleLspRequests) HandleInternal(ctx context.Context, conn jsonrpc2.JS
 			return nil, nil
 		}
 
-		return nil, nil
+		return h.intercept(ctx, req, &params, func() (interface{}, error) {
+			return nil, nil
+		})
 
 	case "textDocument/hover":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
-
 		var params lsp.TextDocumentPositionParams
-
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
-
-		return h.handler.HandleHover(params, out)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleHover(params, out)
+		})
 
 	case "textDocument/definition":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
-
 		var params lsp.TextDocumentPositionParams
-
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
-		return h.handler.HandleGotoDefinition(params, out)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleGotoDefinition(params, out)
+		})
 
 	case "textDocument/declaration":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
-
-		var params lsp.DeclarationOptions
-
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
-
-		return h.handler.HandleGotoDeclaration(params, out)
+		var params declarationParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleGotoDeclaration(params.DeclarationOptions, out)
+		})
 
 	case "textDocument/typeDefinition":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
-
 		var params lsp.TextDocumentPositionParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
-
-		return h.handler.HandleGotoTypeDefinition(params, out)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleGotoTypeDefinition(params, out)
+		})
 
 	case "textDocument/completion":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
-
 		var params lsp.CompletionParams
-
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
-
-		return h.handler.HandleCompletion(params, out)
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleCompletion(params, out)
+		})
 	case "completionItem/resolve":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
-
 		var params lsp.CompletionItem
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleCompletionItemResolve(params, out)
+		})
+	case "textDocument/references":
+		var params lsp.ReferenceParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleFindReferences(params, out)
+		})
+	case "textDocument/implementation":
+		var params lsp.TextDocumentPositionParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleGotoImplementation(params, out)
+		})
+	case "textDocument/documentSymbol":
+		var params lsp.DocumentSymbolParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleSymbol(params, out)
+		})
+	case "textDocument/linkedEditingRange":
+		var params lsp.LinkedEditingRangeParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleLinkedEditingRange(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
+	case "textDocument/semanticTokens/full":
+		var params lsp.SemanticTokensParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.semanticTokensFull(params, out)
+		})
+
+	case "textDocument/semanticTokens/full/delta":
+		var params SemanticTokensDeltaParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.semanticTokensDelta(params, out)
+		})
+
+	case "textDocument/semanticTokens/range":
+		var params SemanticTokensRangeParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.semanticTokensRange(params, out)
+		})
 
-		return h.handler.HandleCompletionItemResolve(params, out)
-	case "textDocument/references":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
+	case "textDocument/signatureHelp":
+		var params lsp.TextDocumentPositionParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleSignatureHelp(params, out)
+		})
 
-		var params lsp.ReferenceParams
+	case "textDocument/formatting":
+		var params lsp.DocumentFormattingParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleFormatting(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
+	case "textDocument/codeAction":
+		var params lsp.CodeActionParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleCodeAction(params, out)
+		})
 
-		return h.handler.HandleFindReferences(params, out)
-	case "textDocument/implementation":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
+	case "textDocument/documentHighlight":
+		var params lsp.DocumentHighlightParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleHighlights(params, out)
+		})
 
-		var params lsp.TextDocumentPositionParams
+	case "textDocument/codeLens":
+		var params lsp.CodeLensParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleCodeLens(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
-		}
-		return h.handler.HandleGotoImplementation(params, out)
-	case "textDocument/documentSymbol":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
+	case "textDocument/rename":
+		var params lsp.RenameParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handler.HandleRename(params)
+		})
 
-		var params lsp.DocumentSymbolParams
+	case "workspace/didChangeWatchedFiles":
+		var params lsp.DidChangeWatchedFilesParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return nil, h.handler.HandleDidChangeWatchedFiles(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "textDocument/foldingRange":
+		foldingHandler, ok := h.handler.(FoldingRangeHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleSymbol(params, out)
-	case "textDocument/linkedEditingRange":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
+		var params FoldingRangeParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			ranges, err := foldingHandler.HandleFoldingRange(params, out)
+			if err != nil {
+				return nil, err
+			}
 
-		var params lsp.LinkedEditingRangeParams
+			return h.limitFoldingRanges(ranges), nil
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "textDocument/selectionRange":
+		selectionHandler, ok := h.handler.(SelectionRangeHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleLinkedEditingRange(params, out)
+		var params SelectionRangeParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return selectionHandler.HandleSelectionRange(params, out)
+		})
 
-	case "textDocument/semanticTokens/full":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	case "textDocument/prepareCallHierarchy":
+		callHandler, ok := h.handler.(CallHierarchyHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		var params lsp.SemanticTokensParams
+		var params lsp.TextDocumentPositionParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return callHandler.HandlePrepareCallHierarchy(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "callHierarchy/incomingCalls":
+		callHandler, ok := h.handler.(CallHierarchyHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleSemanticTokensFull(params, out)
+		var params CallHierarchyIncomingCallsParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return callHandler.HandleCallHierarchyIncomingCalls(params, out)
+		})
 
-	case "textDocument/signatureHelp":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	case "callHierarchy/outgoingCalls":
+		callHandler, ok := h.handler.(CallHierarchyHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		var params lsp.TextDocumentPositionParams
+		var params CallHierarchyOutgoingCallsParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return callHandler.HandleCallHierarchyOutgoingCalls(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "textDocument/prepareTypeHierarchy":
+		typeHandler, ok := h.handler.(TypeHierarchyHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleSignatureHelp(params, out)
+		var params lsp.TextDocumentPositionParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return typeHandler.HandlePrepareTypeHierarchy(params, out)
+		})
 
-	case "textDocument/formatting":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	case "typeHierarchy/supertypes":
+		typeHandler, ok := h.handler.(TypeHierarchyHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		var params lsp.DocumentFormattingParams
+		var params TypeHierarchySupertypesParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return typeHandler.HandleTypeHierarchySupertypes(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "typeHierarchy/subtypes":
+		typeHandler, ok := h.handler.(TypeHierarchyHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleFormatting(params, out)
+		var params TypeHierarchySubtypesParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return typeHandler.HandleTypeHierarchySubtypes(params, out)
+		})
 
-	case "textDocument/codeAction":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	case "textDocument/inlayHint":
+		inlayHandler, ok := h.handler.(InlayHintHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		var params lsp.CodeActionParams
+		var params InlayHintParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return inlayHandler.HandleInlayHint(params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "inlayHint/resolve":
+		resolveHandler, ok := h.handler.(InlayHintResolveHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleCodeAction(params, out)
+		var params InlayHint
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return resolveHandler.HandleInlayHintResolve(params, out)
+		})
 
-	case "textDocument/documentHighlight":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	case "textDocument/diagnostic":
+		diagnosticHandler, ok := h.handler.(DocumentDiagnosticHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		var params lsp.DocumentHighlightParams
+		var params DocumentDiagnosticParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handleDocumentDiagnostic(diagnosticHandler, params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "workspace/diagnostic":
+		workspaceHandler, ok := h.handler.(WorkspaceDiagnosticHandler)
+		if !ok || h.diagnosticProvider() == nil {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return h.handler.HandleHighlights(params, out)
+		var params WorkspaceDiagnosticParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			reporter := newWorkspaceDiagnosticReporter(params, out)
+			if err := workspaceHandler.HandleWorkspaceDiagnostic(params, reporter, out); err != nil {
+				return nil, err
+			}
+
+			return reporter.result(), nil
+		})
 
-	case "textDocument/codeLens":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
+	case "workspace/executeCommand":
+		if len(h.commands) == 0 {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		var params lsp.CodeLensParams
+		var params executeCommandParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.executeCommand(ctx, params, out)
+		})
 
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "workspace/symbol":
+		if h.workspaceSymbolProvider() == nil {
+			return h.handleUnsupported(ctx, req, out)
 		}
-		return h.handler.HandleCodeLens(params, out)
 
-	case "workspace/didChangeWatchedFiles":
-		if req.Params == nil {
-			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams}
-		}
+		var params lsp.WorkspaceSymbolParams
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return h.handleWorkspaceSymbol(params, out)
+		})
 
-		var params lsp.DidChangeWatchedFilesParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			return nil, err
+	case "workspaceSymbol/resolve":
+		resolveHandler, ok := h.handler.(WorkspaceSymbolResolveHandler)
+		if !ok {
+			return h.handleUnsupported(ctx, req, out)
 		}
 
-		return nil, h.handler.HandleDidChangeWatchedFiles(params, out)
+		var params WorkspaceSymbol
+		return h.interceptDecoded(ctx, req, &params, func() (interface{}, error) {
+			return resolveHandler.HandleWorkspaceSymbolResolve(params, out)
+		})
 
 	default:
 		if isFileSystemRequest(req.Method) {
-			err := h.handleFileSystemRequest(ctx, req, out)
-
-			return nil, err
+			return h.handleFileSystemRequest(ctx, req, out)
 		}
 
-		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeMethodNotFound, Message: fmt.Sprintf("HandleLspRequests: request is not supported: %s", req.Method)}
+		return h.handleUnsupported(ctx, req, out)
 	}
 }
+
+// handleUnsupported passes methods without a route, or whose optional handler interface is not implemented,
+// on to the registered methods and the extension handlers.
+func (h *HandleLspRequests) handleUnsupported(ctx context.Context, req *jsonrpc2.Request, out *SendOut) (interface{}, error) {
+	return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+		return h.handleUnknownMethod(ctx, req, out)
+	})
+}
@@ -0,0 +1,90 @@
+package lspserv_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+// stubHandler implements the methods needed by the tests. The other methods of lspserv.Handler panic.
+type stubHandler struct {
+	lspserv.Handler
+
+	hoverDelay time.Duration
+	running    int32
+	overlapped int32
+	shutDown   int32
+}
+
+func (h *stubHandler) Reset() error {
+	return nil
+}
+
+func (h *stubHandler) ShutDown() {
+	atomic.StoreInt32(&h.shutDown, 1)
+}
+
+func (h *stubHandler) enter() func() {
+	if atomic.AddInt32(&h.running, 1) > 1 {
+		atomic.StoreInt32(&h.overlapped, 1)
+	}
+
+	return func() {
+		atomic.AddInt32(&h.running, -1)
+	}
+}
+
+func (h *stubHandler) HandleHover(params lsp.TextDocumentPositionParams, conn lspserv.Connection) (*lsp.Hover, error) {
+	defer h.enter()()
+
+	if h.hoverDelay > 0 {
+		<-conn.Context().Done()
+		time.Sleep(h.hoverDelay)
+	}
+
+	return &lsp.Hover{Contents: lsp.MarkupContent{Kind: "plaintext", Value: "hover"}}, nil
+}
+
+func (h *stubHandler) HandleCompletion(params lsp.CompletionParams, conn lspserv.Connection) (*lsp.CompletionList, error) {
+	defer h.enter()()
+
+	return &lsp.CompletionList{Items: []lsp.CompletionItem{{Label: "completion"}}}, nil
+}
+
+func TestTimedOutHandlerIsNotOverlapped(t *testing.T) {
+	handler := &stubHandler{hoverDelay: 50 * time.Millisecond}
+	service := lspserv.NewService(handler)
+	service.SetRequestTimeout("textDocument/hover", 5*time.Millisecond)
+
+	client := lspservtest.NewServiceClient(service)
+	defer client.Close()
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	hover, err := client.Hover("file:///a.swamp", lsp.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hover != nil {
+		t.Errorf("expected the empty timeout result, got %v", hover)
+	}
+
+	completion, err := client.Completion("file:///a.swamp", lsp.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(completion.Items) != 1 {
+		t.Errorf("expected the completion of the handler, got %v", completion)
+	}
+
+	if atomic.LoadInt32(&handler.overlapped) != 0 {
+		t.Error("completion was handled while the timed out hover was still running")
+	}
+}
@@ -0,0 +1,35 @@
+package lspserv
+
+import (
+	"encoding/json"
+
+	"github.com/piot/go-lsp"
+)
+
+// HierarchyItem is an item in the call or type hierarchy view. The client sends the item back unchanged in the
+// requests for its calls, supertypes or subtypes, including Data.
+type HierarchyItem struct {
+	Name           string          `json:"name"`
+	Kind           lsp.SymbolKind  `json:"kind"`
+	Tags           []lsp.SymbolTag `json:"tags,omitempty"`
+	Detail         string          `json:"detail,omitempty"`
+	URI            lsp.DocumentURI `json:"uri"`
+	Range          lsp.Range       `json:"range"`
+	SelectionRange lsp.Range       `json:"selectionRange"`
+	Data           json.RawMessage `json:"data,omitempty"`
+}
+
+// SetData stores v, e.g. a symbol or type ID, so it does not have to be resolved again from the position.
+func (i *HierarchyItem) SetData(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	i.Data = data
+	return nil
+}
+
+// DecodeData unmarshals the value stored with SetData into v.
+func (i HierarchyItem) DecodeData(v interface{}) error {
+	return json.Unmarshal(i.Data, v)
+}
@@ -0,0 +1,98 @@
+package lspserv
+
+import (
+	"encoding/json"
+
+	"github.com/piot/go-lsp"
+)
+
+type InlayHintKind int
+
+const (
+	InlayHintType      InlayHintKind = 1
+	InlayHintParameter InlayHintKind = 2
+)
+
+type InlayHintParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	Range        lsp.Range                  `json:"range"`
+}
+
+// InlayHintLabelPart is a part of a label that can have its own tooltip, location to go to and command.
+type InlayHintLabelPart struct {
+	Value    string             `json:"value"`
+	Tooltip  *lsp.MarkupContent `json:"tooltip,omitempty"`
+	Location *lsp.Location      `json:"location,omitempty"`
+	Command  *lsp.Command       `json:"command,omitempty"`
+}
+
+// InlayHintLabel is encoded as a plain string, unless it has parts.
+type InlayHintLabel struct {
+	Text  string
+	Parts []InlayHintLabelPart
+}
+
+func (l InlayHintLabel) MarshalJSON() ([]byte, error) {
+	if l.Parts != nil {
+		return json.Marshal(l.Parts)
+	}
+	return json.Marshal(l.Text)
+}
+
+func (l *InlayHintLabel) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		l.Text = ""
+		return json.Unmarshal(data, &l.Parts)
+	}
+	l.Parts = nil
+	return json.Unmarshal(data, &l.Text)
+}
+
+// InlayHint is shown inline at Position, e.g. the inferred type `: int` after a variable name.
+type InlayHint struct {
+	Position     lsp.Position       `json:"position"`
+	Label        InlayHintLabel     `json:"label"`
+	Kind         InlayHintKind      `json:"kind,omitempty"`
+	TextEdits    []lsp.TextEdit     `json:"textEdits,omitempty"`
+	Tooltip      *lsp.MarkupContent `json:"tooltip,omitempty"`
+	PaddingLeft  bool               `json:"paddingLeft,omitempty"`
+	PaddingRight bool               `json:"paddingRight,omitempty"`
+	// Data is kept by the client and sent back in `inlayHint/resolve`.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type InlayHintOptions struct {
+	lsp.WorkDoneProgressOptions
+	ResolveProvider bool `json:"resolveProvider,omitempty"`
+}
+
+// InlayHintHandler can optionally be implemented by a Handler to serve `textDocument/inlayHint`.
+type InlayHintHandler interface {
+	HandleInlayHint(params InlayHintParams, conn Connection) ([]InlayHint, error)
+}
+
+// InlayHintResolveHandler fills in the tooltip, text edits or label locations of a hint when the client needs them.
+type InlayHintResolveHandler interface {
+	HandleInlayHintResolve(hint InlayHint, conn Connection) (*InlayHint, error)
+}
+
+func (h *HandleLspRequests) inlayHintProvider() *InlayHintOptions {
+	if _, ok := h.handler.(InlayHintHandler); !ok {
+		return nil
+	}
+
+	_, canResolve := h.handler.(InlayHintResolveHandler)
+
+	return &InlayHintOptions{ResolveProvider: canResolve}
+}
+
+// RequestInlayHintRefresh asks the client to request the inlay hints of all visible documents again, e.g.
+// after a change in one file altered the inferred types in another. It does nothing if the client does not
+// support it, or if s is not part of a request, since the capabilities of the client are not known then.
+func (s *SendOut) RequestInlayHintRefresh() error {
+	if s.requests == nil || !s.requests.clientCapabilities.Workspace.InlayHint.RefreshSupport {
+		return nil
+	}
+
+	return s.dispatchRequest("workspace/inlayHint/refresh", nil)
+}
@@ -0,0 +1,13 @@
+package lspserv_test
+
+import (
+	"testing"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+func TestRequestInlayHintRefreshOutsideOfRequest(t *testing.T) {
+	if err := lspserv.NewSendOut(nil, nil).RequestInlayHintRefresh(); err != nil {
+		t.Errorf("expected the refresh to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package lspserv
+
+import (
+	"context"
+
+	"github.com/piot/jsonrpc2"
+)
+
+// Call describes a request or notification on its way to the Handler.
+type Call struct {
+	Method string
+	ID     jsonrpc2.ID
+	Notif  bool
+
+	// Params points to the decoded parameters, e.g. *lsp.TextDocumentPositionParams, so an interceptor
+	// can inspect or rewrite them before calling next. It is the raw *json.RawMessage for methods
+	// the dispatcher does not decode, for messages rejected by the lifecycle rules and for params
+	// that failed to decode.
+	Params interface{}
+}
+
+// Interceptor is called around the dispatch of every request and notification, including the ones
+// that are answered with an error before reaching the Handler. It must call next to continue
+// dispatching and may inspect or replace the result and error it returns.
+type Interceptor func(ctx context.Context, call *Call, next func() (interface{}, error)) (interface{}, error)
+
+// AddInterceptor appends an interceptor to the chain. The first added interceptor is the outermost.
+func (h *HandleLspRequests) AddInterceptor(interceptor Interceptor) {
+	h.interceptors = append(h.interceptors, interceptor)
+}
+
+func (h *HandleLspRequests) intercept(ctx context.Context, req *jsonrpc2.Request, params interface{}, invoke func() (interface{}, error)) (interface{}, error) {
+	call := &Call{Method: req.Method, ID: req.ID, Notif: req.Notif, Params: params}
+
+	if info, ok := ctx.Value(requestInfoContextKey{}).(*requestInfo); ok {
+		info.setDocumentURI(params)
+	}
+
+	next := invoke
+	for i := len(h.interceptors) - 1; i >= 0; i-- {
+		interceptor := h.interceptors[i]
+		inner := next
+		next = func() (interface{}, error) {
+			return interceptor(ctx, call, inner)
+		}
+	}
+
+	return next()
+}
+
+// interceptDecoded decodes the params of req into params before dispatching. When decoding fails, the
+// interceptors see the raw params and next returns the InvalidParams error instead of calling invoke.
+func (h *HandleLspRequests) interceptDecoded(ctx context.Context, req *jsonrpc2.Request, params interface{}, invoke func() (interface{}, error)) (interface{}, error) {
+	if err := h.decodeParams(req, params); err != nil {
+		return h.intercept(ctx, req, req.Params, func() (interface{}, error) {
+			return nil, err
+		})
+	}
+
+	return h.intercept(ctx, req, params, invoke)
+}
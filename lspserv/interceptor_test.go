@@ -0,0 +1,69 @@
+package lspserv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+type interceptedCall struct {
+	method string
+	params interface{}
+	err    error
+}
+
+func newInterceptedClient(handler lspserv.Handler) (*lspservtest.Client, *[]interceptedCall) {
+	var calls []interceptedCall
+
+	service := lspserv.NewService(handler)
+	service.AddInterceptor(func(ctx context.Context, call *lspserv.Call, next func() (interface{}, error)) (interface{}, error) {
+		result, err := next()
+		calls = append(calls, interceptedCall{method: call.Method, params: call.Params, err: err})
+		return result, err
+	})
+
+	return lspservtest.NewServiceClient(service), &calls
+}
+
+func TestInterceptorSeesInvalidParams(t *testing.T) {
+	client, calls := newInterceptedClient(&stubHandler{})
+	defer client.Close()
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.Call("textDocument/hover", json.RawMessage(`{"textDocument":5}`), nil)
+	expectCode(t, err, jsonrpc2.CodeInvalidParams)
+
+	last := (*calls)[len(*calls)-1]
+	if last.method != "textDocument/hover" {
+		t.Fatalf("expected the interceptor to see the hover, got %s", last.method)
+	}
+	expectCode(t, last.err, jsonrpc2.CodeInvalidParams)
+	if _, isRaw := last.params.(*json.RawMessage); !isRaw {
+		t.Errorf("expected the raw params for params that failed to decode, got %T", last.params)
+	}
+}
+
+func TestInterceptorSeesLifecycleErrors(t *testing.T) {
+	client, calls := newInterceptedClient(&stubHandler{})
+	defer client.Close()
+
+	_, err := client.Hover("file:///a.swamp", lsp.Position{})
+	expectCode(t, err, lspserv.CodeServerNotInitialized)
+
+	if len(*calls) != 1 || (*calls)[0].method != "textDocument/hover" {
+		t.Fatalf("expected the interceptor to see the hover before initialize, got %v", *calls)
+	}
+	if !errors.Is((*calls)[0].err, lspserv.ErrServerNotInitialized) {
+		t.Errorf("expected the interceptor to see ErrServerNotInitialized, got %v", (*calls)[0].err)
+	}
+}
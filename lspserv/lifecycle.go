@@ -0,0 +1,74 @@
+package lspserv
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/piot/jsonrpc2"
+)
+
+// ErrExitWithoutShutdown is returned from RunUntilClose when the client sent `exit` without a `shutdown` request
+// first. The specification requires the process to exit with code 1 in that case.
+var ErrExitWithoutShutdown = errors.New("exit notification received before shutdown")
+
+type serverState int32
+
+const (
+	stateUninitialized serverState = iota
+	stateInitialized
+	stateShutdown
+)
+
+type lifecycle struct {
+	state               int32
+	exitWithoutShutdown int32
+}
+
+func (l *lifecycle) get() serverState {
+	return serverState(atomic.LoadInt32(&l.state))
+}
+
+func (l *lifecycle) set(state serverState) {
+	atomic.StoreInt32(&l.state, int32(state))
+}
+
+func (l *lifecycle) exit() {
+	if l.get() != stateShutdown {
+		atomic.StoreInt32(&l.exitWithoutShutdown, 1)
+	}
+}
+
+func (l *lifecycle) exitedWithoutShutdown() bool {
+	return atomic.LoadInt32(&l.exitWithoutShutdown) != 0
+}
+
+// admit applies the lifecycle rules of the specification before a message is dispatched: before `initialize`
+// requests fail with ServerNotInitialized and after `shutdown` with InvalidRequest, while notifications are
+// dropped in both cases. `exit` is always admitted. Returns false for messages that must not be dispatched.
+func (h *HandleLspRequests) admit(req *jsonrpc2.Request) (bool, error) {
+	if req.Method == "exit" {
+		return true, nil
+	}
+
+	switch h.lifecycle.get() {
+	case stateUninitialized:
+		if req.Method == "initialize" {
+			return true, nil
+		}
+		if req.Notif {
+			h.logger.Log(LogDebug, "HandleLspRequests: dropped notification before initialize", Field("method", req.Method))
+			return false, nil
+		}
+		return false, fmt.Errorf("HandleLspRequests: %s before initialize: %w", req.Method, ErrServerNotInitialized)
+
+	case stateShutdown:
+		if req.Notif {
+			h.logger.Log(LogDebug, "HandleLspRequests: dropped notification after shutdown", Field("method", req.Method))
+			return false, nil
+		}
+		return false, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidRequest, Message: fmt.Sprintf("HandleLspRequests: %s after shutdown", req.Method)}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,80 @@
+package lspserv_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+func expectCode(t *testing.T, err error, code int64) {
+	t.Helper()
+
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("expected error code %d, got %v", code, err)
+	}
+	if rpcErr.Code != code {
+		t.Errorf("expected error code %d, got %d: %s", code, rpcErr.Code, rpcErr.Message)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	handler := &stubHandler{}
+	client := lspservtest.NewClient(handler)
+
+	_, err := client.Hover("file:///a.swamp", lsp.Position{})
+	expectCode(t, err, lspserv.CodeServerNotInitialized)
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Initialize(lsp.InitializeParams{})
+	expectCode(t, err, jsonrpc2.CodeInvalidRequest)
+
+	if _, err := client.Hover("file:///a.swamp", lsp.Position{}); err != nil {
+		t.Fatalf("expected hover to be handled once initialized, got %v", err)
+	}
+
+	if err := client.Call("shutdown", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&handler.shutDown) == 0 {
+		t.Error("expected the handler to be shut down")
+	}
+
+	_, err = client.Hover("file:///a.swamp", lsp.Position{})
+	expectCode(t, err, jsonrpc2.CodeInvalidRequest)
+
+	if err := client.Notify("exit", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("expected exit after shutdown to stop cleanly, got %v", err)
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	for _, initialize := range []bool{false, true} {
+		client := lspservtest.NewClient(&stubHandler{})
+
+		if initialize {
+			if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := client.Notify("exit", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Close(); !errors.Is(err, lspserv.ErrExitWithoutShutdown) {
+			t.Errorf("initialized %v: expected ErrExitWithoutShutdown, got %v", initialize, err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package lspserv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// LogField is a key value pair attached to a log line, e.g. the method, request ID, duration or URI.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+func Field(key string, value interface{}) LogField {
+	return LogField{Key: key, Value: value}
+}
+
+// Logger receives all log lines from lspserv. Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, message string, fields ...LogField)
+}
+
+type textLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel LogLevel
+}
+
+// NewTextLogger writes human readable lines, e.g. `2021/03/08 10:03:31 error: message method=textDocument/hover`.
+func NewTextLogger(w io.Writer, minLevel LogLevel) Logger {
+	return &textLogger{w: w, minLevel: minLevel}
+}
+
+func (l *textLogger) Log(level LogLevel, message string, fields ...LogField) {
+	if level < l.minLevel {
+		return
+	}
+
+	line := fmt.Sprintf("%s %v: %s%s\n", time.Now().Format("2006/01/02 15:04:05"), level, message, formatFields(fields))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line)
+}
+
+func formatFields(fields []LogField) string {
+	var b strings.Builder
+	for _, field := range fields {
+		fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+	}
+	return b.String()
+}
+
+type jsonLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel LogLevel
+}
+
+// NewJSONLogger writes one JSON object per line with the time, level, message and all fields as properties.
+func NewJSONLogger(w io.Writer, minLevel LogLevel) Logger {
+	return &jsonLogger{w: w, minLevel: minLevel}
+}
+
+func (l *jsonLogger) Log(level LogLevel, message string, fields ...LogField) {
+	if level < l.minLevel {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+3)
+	for _, field := range fields {
+		value := field.Value
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case fmt.Stringer:
+			value = v.String()
+		}
+		entry[field.Key] = value
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = message
+
+	octets, err := json.Marshal(entry)
+	if err != nil {
+		octets, _ = json.Marshal(map[string]string{"level": LogError.String(), "msg": fmt.Sprintf("jsonLogger: could not encode %q: %v", message, err)})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(octets, '\n'))
+}
+
+// clientLogger forwards log lines to the client using `window/logMessage`. Lines are written to fallback
+// until the connection is available or if sending fails.
+type clientLogger struct {
+	mu       sync.Mutex
+	conn     *jsonrpc2.Conn
+	minLevel LogLevel
+	fallback Logger
+}
+
+func newClientLogger(minLevel LogLevel, fallback Logger) *clientLogger {
+	return &clientLogger{minLevel: minLevel, fallback: fallback}
+}
+
+// connOpt hooks up the logger before the connection starts reading messages.
+func (l *clientLogger) connOpt() jsonrpc2.ConnOpt {
+	return func(c *jsonrpc2.Conn) {
+		l.mu.Lock()
+		l.conn = c
+		l.mu.Unlock()
+	}
+}
+
+func (l *clientLogger) Log(level LogLevel, message string, fields ...LogField) {
+	if level < l.minLevel {
+		return
+	}
+
+	l.mu.Lock()
+	conn := l.conn
+	l.mu.Unlock()
+
+	if conn != nil {
+		params := lsp.LogMessageParams{Type: messageTypeFromLevel(level), Message: message + formatFields(fields)}
+		if err := conn.Notify(context.Background(), "window/logMessage", params); err == nil {
+			return
+		}
+	}
+
+	l.fallback.Log(level, message, fields...)
+}
+
+func messageTypeFromLevel(level LogLevel) lsp.MessageType {
+	switch level {
+	case LogError:
+		return lsp.MTError
+	case LogWarn:
+		return lsp.MTWarning
+	case LogInfo:
+		return lsp.MTInfo
+	default:
+		return lsp.MTLog
+	}
+}
+
+// printfLogger adapts a Logger to the Printf logger used by jsonrpc2.
+type printfLogger struct {
+	logger Logger
+	level  LogLevel
+}
+
+func (l printfLogger) Printf(format string, v ...interface{}) {
+	l.logger.Log(l.level, strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+}
+
+var defaultLogger = NewTextLogger(os.Stderr, LogInfo)
+
+type requestInfoContextKey struct{}
+
+// requestInfo collects what the dispatcher learns about a request while handling it, so the log lines do not
+// have to decode the params again. It is written from the goroutine of a timed out handler, which may still be
+// running when the request is logged.
+type requestInfo struct {
+	uri atomic.Value
+}
+
+// setDocumentURI takes the URI from the TextDocument field of the decoded params, if they have one.
+func (i *requestInfo) setDocumentURI(params interface{}) {
+	value := reflect.ValueOf(params)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	document := value.FieldByName("TextDocument")
+	if document.Kind() != reflect.Struct {
+		return
+	}
+
+	uri := document.FieldByName("URI")
+	if uri.Kind() != reflect.String || uri.String() == "" {
+		return
+	}
+
+	i.uri.Store(lsp.DocumentURI(uri.String()))
+}
+
+func (i *requestInfo) documentURI() lsp.DocumentURI {
+	uri, _ := i.uri.Load().(lsp.DocumentURI)
+	return uri
+}
@@ -0,0 +1,70 @@
+package lspserv_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []map[string]interface{}
+}
+
+func (l *recordingLogger) Log(level lspserv.LogLevel, message string, fields ...lspserv.LogField) {
+	line := map[string]interface{}{"msg": message}
+	for _, field := range fields {
+		line[field.Key] = field.Value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func (l *recordingLogger) find(message string, method string) map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range l.lines {
+		if line["msg"] == message && line["method"] == method {
+			return line
+		}
+	}
+
+	return nil
+}
+
+func TestRequestLogHasDocumentURI(t *testing.T) {
+	logger := &recordingLogger{}
+	service := lspserv.NewService(&stubHandler{})
+	service.SetLogger(logger)
+
+	client := lspservtest.NewServiceClient(service)
+	defer client.Close()
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Hover("file:///a.swamp", lsp.Position{}); err != nil {
+		t.Fatal(err)
+	}
+
+	line := logger.find("HandleLspRequests: request handled", "textDocument/hover")
+	if line == nil {
+		t.Fatal("hover was not logged")
+	}
+	if uri := line["uri"]; uri != lsp.DocumentURI("file:///a.swamp") {
+		t.Errorf("expected the URI of the hover, got %v", uri)
+	}
+
+	line = logger.find("HandleLspRequests: request handled", "initialize")
+	if _, hasURI := line["uri"]; line == nil || hasURI {
+		t.Errorf("expected initialize to be logged without a URI, got %v", line)
+	}
+}
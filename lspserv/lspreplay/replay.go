@@ -0,0 +1,232 @@
+// Package lspreplay feeds the client messages of a session recorded by lspserv.Recorder into a language
+// server and compares the responses with the recorded ones.
+package lspreplay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+// ReadTranscript reads the JSON lines written by lspserv.Recorder.
+func ReadTranscript(r io.Reader) ([]lspserv.RecordedMessage, error) {
+	var transcript []lspserv.RecordedMessage
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var message lspserv.RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return nil, fmt.Errorf("lspreplay: line %d: %w", lineNumber, err)
+		}
+		transcript = append(transcript, message)
+	}
+
+	return transcript, scanner.Err()
+}
+
+// Diff is a request where the replayed response differs from the recorded one.
+type Diff struct {
+	ID     string
+	Method string
+	// Expected is the recorded response, or nil if none was recorded.
+	Expected json.RawMessage
+	// Actual is the replayed response, or nil if the server did not respond in time.
+	Actual json.RawMessage
+}
+
+func (d Diff) String() string {
+	return fmt.Sprintf("request %s %s:\n  expected: %s\n  actual:   %s", d.ID, d.Method, orNone(d.Expected), orNone(d.Actual))
+}
+
+func orNone(message json.RawMessage) string {
+	if message == nil {
+		return "<none>"
+	}
+	return string(message)
+}
+
+type Report struct {
+	Requests int
+	Diffs    []Diff
+}
+
+// message holds the fields that tell requests, notifications and responses apart.
+type message struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Result *json.RawMessage `json:"result"`
+	Error  *json.RawMessage `json:"error"`
+}
+
+func (m message) isResponse() bool {
+	return m.Method == "" && m.ID != nil
+}
+
+func (m message) key() string {
+	if m.ID == nil {
+		return ""
+	}
+	return string(*m.ID)
+}
+
+// ReplayHandler replays the transcript against a Service built from handler, connected over an in-memory pipe.
+func ReplayHandler(handler lspserv.Handler, transcript []lspserv.RecordedMessage, timeout time.Duration) (*Report, error) {
+	serverSide, clientSide := net.Pipe()
+	service := lspserv.NewService(handler)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.RunUntilClose(serverSide, false)
+	}()
+
+	report, err := Replay(clientSide, transcript, timeout)
+	clientSide.Close()
+	<-done
+
+	return report, err
+}
+
+// Replay sends the recorded client messages in order over rwc, e.g. the stdio of a server process. After each
+// request it waits up to timeout for the response and compares it with the recorded one. Requests from the
+// server are answered with a null result.
+func Replay(rwc io.ReadWriteCloser, transcript []lspserv.RecordedMessage, timeout time.Duration) (*Report, error) {
+	expected := make(map[string]json.RawMessage)
+	for _, recorded := range transcript {
+		var m message
+		if err := json.Unmarshal(recorded.Message, &m); err != nil {
+			return nil, err
+		}
+		if recorded.Direction == lspserv.RecordOutgoing && m.isResponse() {
+			expected[m.key()] = recorded.Message
+		}
+	}
+
+	stream := jsonrpc2.NewBufferedStream(rwc, jsonrpc2.VSCodeObjectCodec{})
+	responses := newResponseWaiter()
+	go readFromServer(stream, responses)
+
+	report := &Report{}
+	for _, recorded := range transcript {
+		if recorded.Direction != lspserv.RecordIncoming {
+			continue
+		}
+
+		var m message
+		if err := json.Unmarshal(recorded.Message, &m); err != nil {
+			return report, err
+		}
+
+		if m.isResponse() {
+			// Answers to server requests are generated while reading.
+			continue
+		}
+
+		isRequest := m.ID != nil
+		var response <-chan json.RawMessage
+		if isRequest {
+			response = responses.expect(m.key())
+		}
+
+		if err := stream.WriteObject(recorded.Message); err != nil {
+			return report, fmt.Errorf("lspreplay: sending %s: %w", m.Method, err)
+		}
+
+		if !isRequest {
+			continue
+		}
+
+		report.Requests++
+
+		var actual json.RawMessage
+		select {
+		case actual = <-response:
+		case <-time.After(timeout):
+		}
+
+		if !sameResponse(expected[m.key()], actual) {
+			report.Diffs = append(report.Diffs, Diff{ID: m.key(), Method: m.Method, Expected: expected[m.key()], Actual: actual})
+		}
+	}
+
+	return report, nil
+}
+
+func readFromServer(stream jsonrpc2.ObjectStream, responses *responseWaiter) {
+	for {
+		var raw json.RawMessage
+		if err := stream.ReadObject(&raw); err != nil {
+			return
+		}
+
+		var m message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			continue
+		}
+
+		switch {
+		case m.isResponse():
+			responses.deliver(m.key(), raw)
+		case m.ID != nil:
+			// The reply is written from another goroutine, since the server may be writing a response at the
+			// same time, which is not read until this loop continues.
+			reply := map[string]interface{}{"jsonrpc": "2.0", "id": m.ID, "result": nil}
+			go stream.WriteObject(reply)
+		}
+	}
+}
+
+// sameResponse compares the result and error of two responses, ignoring formatting.
+func sameResponse(expected json.RawMessage, actual json.RawMessage) bool {
+	if expected == nil || actual == nil {
+		return expected == nil && actual == nil
+	}
+
+	var expectedValue, actualValue struct {
+		Result interface{} `json:"result"`
+		Error  interface{} `json:"error"`
+	}
+	if json.Unmarshal(expected, &expectedValue) != nil || json.Unmarshal(actual, &actualValue) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(expectedValue, actualValue)
+}
+
+type responseWaiter struct {
+	mu      sync.Mutex
+	waiting map[string]chan json.RawMessage
+}
+
+func newResponseWaiter() *responseWaiter {
+	return &responseWaiter{waiting: make(map[string]chan json.RawMessage)}
+}
+
+func (w *responseWaiter) expect(id string) <-chan json.RawMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan json.RawMessage, 1)
+	w.waiting[id] = ch
+	return ch
+}
+
+func (w *responseWaiter) deliver(id string, response json.RawMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ch, ok := w.waiting[id]; ok {
+		ch <- response
+		delete(w.waiting, id)
+	}
+}
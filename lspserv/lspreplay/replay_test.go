@@ -0,0 +1,112 @@
+package lspreplay_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspreplay"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+// hoverHandler hovers with a fixed prefix and the text of the document. The other methods of lspserv.Handler panic.
+type hoverHandler struct {
+	lspserv.Handler
+
+	prefix string
+	text   string
+}
+
+func (h *hoverHandler) Reset() error {
+	return nil
+}
+
+func (h *hoverHandler) ShutDown() {
+}
+
+func (h *hoverHandler) HandleDidOpen(params lsp.DidOpenTextDocumentParams, conn lspserv.Connection) error {
+	h.text = params.TextDocument.Text
+	return nil
+}
+
+func (h *hoverHandler) HandleHover(params lsp.TextDocumentPositionParams, conn lspserv.Connection) (*lsp.Hover, error) {
+	return &lsp.Hover{Contents: lsp.MarkupContent{Kind: "plaintext", Value: h.prefix + h.text}}, nil
+}
+
+func recordSession(t *testing.T, handler lspserv.Handler) []lspserv.RecordedMessage {
+	t.Helper()
+
+	var recording bytes.Buffer
+	recorder := lspserv.NewRecorder(&recording)
+
+	service := lspserv.NewService(handler)
+	service.RecordTo(recorder)
+	client := lspservtest.NewServiceClient(service)
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.OpenDocument("file:///main.swamp", "swamp", "a = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Hover("file:///main.swamp", lsp.Position{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript, err := lspreplay.ReadTranscript(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transcript
+}
+
+func TestReplayHandler(t *testing.T) {
+	transcript := recordSession(t, &hoverHandler{prefix: "value: "})
+
+	report, err := lspreplay.ReplayHandler(&hoverHandler{prefix: "value: "}, transcript, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 3 {
+		t.Errorf("expected initialize, hover and shutdown to be replayed, got %d requests", report.Requests)
+	}
+	if len(report.Diffs) != 0 {
+		t.Errorf("expected no diffs against the same handler, got %v", report.Diffs)
+	}
+
+	report, err = lspreplay.ReplayHandler(&hoverHandler{prefix: "type: "}, transcript, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Diffs) != 1 {
+		t.Fatalf("expected one diff against the changed handler, got %v", report.Diffs)
+	}
+	if diff := report.Diffs[0]; diff.Method != "textDocument/hover" || !strings.Contains(string(diff.Actual), "type: a = 2") {
+		t.Errorf("expected the hover to differ, got %v", diff)
+	}
+}
+
+func TestReadTranscriptReportsLine(t *testing.T) {
+	recording := `{"direction":"in","message":{"jsonrpc":"2.0","method":"initialized"}}
+
+not json
+`
+
+	if _, err := lspreplay.ReadTranscript(strings.NewReader(recording)); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected an error for line 3, got %v", err)
+	}
+}
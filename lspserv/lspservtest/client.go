@@ -0,0 +1,442 @@
+// Package lspservtest provides a typed LSP client connected to a lspserv.Service over an in-memory pipe,
+// for writing Go tests of Handler implementations:
+//
+//	client := lspservtest.NewClient(&MyHandler{})
+//	defer client.Close()
+//
+//	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+//		t.Fatal(err)
+//	}
+//	client.OpenDocument("file:///main.swamp", "swamp", "a = 2")
+//	hover, err := client.Hover("file:///main.swamp", lsp.Position{Line: 0, Character: 0})
+package lspservtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+// DefaultTimeout is how long a Client waits for responses and notifications, unless Timeout is changed.
+const DefaultTimeout = 5 * time.Second
+
+type Client struct {
+	// Timeout bounds every request and Await call.
+	Timeout time.Duration
+
+	conn       *jsonrpc2.Conn
+	clientSide net.Conn
+	serverDone chan error
+
+	mu            sync.Mutex
+	notifications map[string][]json.RawMessage
+	notified      chan struct{}
+	versions      map[lsp.DocumentURI]int
+}
+
+// NewClient starts a Service built from handler and connects a client to it.
+func NewClient(handler lspserv.Handler) *Client {
+	return NewServiceClient(lspserv.NewService(handler))
+}
+
+// NewServiceClient connects a client to an already configured service, e.g. with interceptors or timeouts.
+func NewServiceClient(service lspserv.Service) *Client {
+	serverSide, clientSide := net.Pipe()
+
+	c := &Client{
+		Timeout:       DefaultTimeout,
+		clientSide:    clientSide,
+		serverDone:    make(chan error, 1),
+		notifications: make(map[string][]json.RawMessage),
+		notified:      make(chan struct{}),
+		versions:      make(map[lsp.DocumentURI]int),
+	}
+
+	go func() {
+		c.serverDone <- service.RunUntilClose(serverSide, false)
+	}()
+
+	c.conn = jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(clientSide, jsonrpc2.VSCodeObjectCodec{}), clientHandler{client: c})
+
+	return c
+}
+
+// clientHandler receives the messages sent from the server. Notifications are queued for the
+// Await functions and requests, e.g. refresh requests, are answered with a null result.
+type clientHandler struct {
+	client *Client
+}
+
+func (h clientHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	c := h.client
+
+	if !req.Notif {
+		// The pipe is unbuffered, so replying here would block while the server is writing a response.
+		go conn.Reply(ctx, req.ID, nil)
+		return
+	}
+
+	var params json.RawMessage
+	if req.Params != nil {
+		params = *req.Params
+	}
+
+	c.mu.Lock()
+	c.notifications[req.Method] = append(c.notifications[req.Method], params)
+	close(c.notified)
+	c.notified = make(chan struct{})
+	c.mu.Unlock()
+}
+
+// Close closes the connection and waits for the service to stop.
+func (c *Client) Close() error {
+	c.conn.Close()
+	c.clientSide.Close()
+
+	select {
+	case err := <-c.serverDone:
+		return err
+	case <-time.After(c.Timeout):
+		return fmt.Errorf("lspservtest: service did not stop within %v", c.Timeout)
+	}
+}
+
+// Call sends any request and decodes the response into result.
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	return c.conn.Call(ctx, method, params, result)
+}
+
+// Notify sends any notification.
+func (c *Client) Notify(method string, params interface{}) error {
+	return c.conn.Notify(context.Background(), method, params)
+}
+
+// AwaitNotification returns the params of the oldest unconsumed notification of method sent by the server.
+func (c *Client) AwaitNotification(method string) (json.RawMessage, error) {
+	deadline := time.After(c.Timeout)
+
+	for {
+		c.mu.Lock()
+		if queued := c.notifications[method]; len(queued) > 0 {
+			c.notifications[method] = queued[1:]
+			c.mu.Unlock()
+			return queued[0], nil
+		}
+		notified := c.notified
+		c.mu.Unlock()
+
+		select {
+		case <-notified:
+		case <-deadline:
+			return nil, fmt.Errorf("lspservtest: no %s notification within %v", method, c.Timeout)
+		}
+	}
+}
+
+// AwaitDiagnostics returns the oldest unconsumed diagnostics published for uri. Diagnostics published for other
+// documents in the meantime are kept for later calls.
+func (c *Client) AwaitDiagnostics(uri lsp.DocumentURI) (lsp.PublishDiagnosticsParams, error) {
+	const method = "textDocument/publishDiagnostics"
+	deadline := time.After(c.Timeout)
+
+	for {
+		c.mu.Lock()
+		queued := c.notifications[method]
+		for i, raw := range queued {
+			var params lsp.PublishDiagnosticsParams
+			if err := json.Unmarshal(raw, &params); err != nil {
+				c.mu.Unlock()
+				return params, err
+			}
+			if params.URI == uri {
+				c.notifications[method] = append(queued[:i:i], queued[i+1:]...)
+				c.mu.Unlock()
+				return params, nil
+			}
+		}
+		notified := c.notified
+		c.mu.Unlock()
+
+		select {
+		case <-notified:
+		case <-deadline:
+			return lsp.PublishDiagnosticsParams{}, fmt.Errorf("lspservtest: no diagnostics for %s within %v", uri, c.Timeout)
+		}
+	}
+}
+
+// Initialize sends `initialize` followed by the `initialized` notification.
+func (c *Client) Initialize(params lsp.InitializeParams) (*lspserv.InitializeResult, error) {
+	var result lspserv.InitializeResult
+	if err := c.Call("initialize", params, &result); err != nil {
+		return nil, err
+	}
+
+	if err := c.Notify("initialized", struct{}{}); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Shutdown sends `shutdown` followed by the `exit` notification.
+func (c *Client) Shutdown() error {
+	if err := c.Call("shutdown", nil, nil); err != nil {
+		return err
+	}
+
+	return c.Notify("exit", nil)
+}
+
+func (c *Client) OpenDocument(uri lsp.DocumentURI, languageID string, text string) error {
+	c.mu.Lock()
+	c.versions[uri] = 1
+	c.mu.Unlock()
+
+	return c.Notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: languageID, Version: 1, Text: text},
+	})
+}
+
+// fullContentChange replaces the whole document, which lsp.TextDocumentContentChangeEvent can not express
+// since it always includes a range.
+type fullContentChange struct {
+	Text string `json:"text"`
+}
+
+// ChangeDocument replaces the whole text of the document and increments its version.
+func (c *Client) ChangeDocument(uri lsp.DocumentURI, text string) error {
+	c.mu.Lock()
+	c.versions[uri]++
+	version := c.versions[uri]
+	c.mu.Unlock()
+
+	return c.Notify("textDocument/didChange", struct {
+		TextDocument   lsp.VersionedTextDocumentIdentifier `json:"textDocument"`
+		ContentChanges []fullContentChange                 `json:"contentChanges"`
+	}{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri}, Version: version},
+		ContentChanges: []fullContentChange{{Text: text}},
+	})
+}
+
+// EditDocument applies incremental changes and increments the version of the document.
+func (c *Client) EditDocument(uri lsp.DocumentURI, changes ...lsp.TextDocumentContentChangeEvent) error {
+	c.mu.Lock()
+	c.versions[uri]++
+	version := c.versions[uri]
+	c.mu.Unlock()
+
+	return c.Notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri}, Version: version},
+		ContentChanges: changes,
+	})
+}
+
+func (c *Client) CloseDocument(uri lsp.DocumentURI) error {
+	c.mu.Lock()
+	delete(c.versions, uri)
+	c.mu.Unlock()
+
+	return c.Notify("textDocument/didClose", lsp.DidCloseTextDocumentParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}})
+}
+
+func positionParams(uri lsp.DocumentURI, position lsp.Position) lsp.TextDocumentPositionParams {
+	return lsp.TextDocumentPositionParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Position: position}
+}
+
+func (c *Client) Hover(uri lsp.DocumentURI, position lsp.Position) (*lsp.Hover, error) {
+	var result *lsp.Hover
+	err := c.Call("textDocument/hover", positionParams(uri, position), &result)
+	return result, err
+}
+
+func (c *Client) Completion(uri lsp.DocumentURI, position lsp.Position) (*lsp.CompletionList, error) {
+	var result *lsp.CompletionList
+	err := c.Call("textDocument/completion", lsp.CompletionParams{TextDocumentPositionParams: positionParams(uri, position)}, &result)
+	return result, err
+}
+
+func (c *Client) Definition(uri lsp.DocumentURI, position lsp.Position) (*lsp.Location, error) {
+	var result *lsp.Location
+	err := c.Call("textDocument/definition", positionParams(uri, position), &result)
+	return result, err
+}
+
+func (c *Client) TypeDefinition(uri lsp.DocumentURI, position lsp.Position) (*lsp.Location, error) {
+	var result *lsp.Location
+	err := c.Call("textDocument/typeDefinition", positionParams(uri, position), &result)
+	return result, err
+}
+
+func (c *Client) References(uri lsp.DocumentURI, position lsp.Position, includeDeclaration bool) ([]lsp.Location, error) {
+	var result []lsp.Location
+	err := c.Call("textDocument/references", lsp.ReferenceParams{
+		TextDocumentPositionParams: positionParams(uri, position),
+		Context:                    lsp.ReferenceContext{IncludeDeclaration: includeDeclaration},
+	}, &result)
+	return result, err
+}
+
+func (c *Client) SignatureHelp(uri lsp.DocumentURI, position lsp.Position) (*lsp.SignatureHelp, error) {
+	var result *lsp.SignatureHelp
+	err := c.Call("textDocument/signatureHelp", positionParams(uri, position), &result)
+	return result, err
+}
+
+func (c *Client) DocumentSymbols(uri lsp.DocumentURI) ([]lsp.DocumentSymbol, error) {
+	var result []lsp.DocumentSymbol
+	err := c.Call("textDocument/documentSymbol", lsp.DocumentSymbolParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}, &result)
+	return result, err
+}
+
+func (c *Client) Formatting(uri lsp.DocumentURI, options lsp.FormattingOptions) ([]lsp.TextEdit, error) {
+	var result []lsp.TextEdit
+	err := c.Call("textDocument/formatting", lsp.DocumentFormattingParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Options: options}, &result)
+	return result, err
+}
+
+func (c *Client) Rename(uri lsp.DocumentURI, position lsp.Position, newName string) (*lsp.WorkspaceEdit, error) {
+	var result *lsp.WorkspaceEdit
+	err := c.Call("textDocument/rename", lsp.RenameParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Position: position, NewName: newName}, &result)
+	return result, err
+}
+
+func (c *Client) CodeLens(uri lsp.DocumentURI) ([]lsp.CodeLens, error) {
+	var result []lsp.CodeLens
+	err := c.Call("textDocument/codeLens", lsp.CodeLensParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}, &result)
+	return result, err
+}
+
+func (c *Client) SemanticTokensFull(uri lsp.DocumentURI) (*lsp.SemanticTokens, error) {
+	var result *lsp.SemanticTokens
+	err := c.Call("textDocument/semanticTokens/full", lsp.SemanticTokensParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}, &result)
+	return result, err
+}
+
+// SemanticTokensDelta requests the edits to the result with previousResultID. The server replies with full tokens
+// instead if it no longer has that result, in which case only Full is set.
+func (c *Client) SemanticTokensDelta(uri lsp.DocumentURI, previousResultID string) (delta *lspserv.SemanticTokensDelta, full *lsp.SemanticTokens, err error) {
+	var result json.RawMessage
+	params := lspserv.SemanticTokensDeltaParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, PreviousResultID: previousResultID}
+	if err := c.Call("textDocument/semanticTokens/full/delta", params, &result); err != nil {
+		return nil, nil, err
+	}
+
+	var kind struct {
+		Edits json.RawMessage `json:"edits"`
+	}
+	if err := json.Unmarshal(result, &kind); err != nil {
+		return nil, nil, err
+	}
+
+	if kind.Edits != nil {
+		err = json.Unmarshal(result, &delta)
+		return delta, nil, err
+	}
+
+	err = json.Unmarshal(result, &full)
+	return nil, full, err
+}
+
+func (c *Client) SemanticTokensRange(uri lsp.DocumentURI, r lsp.Range) (*lsp.SemanticTokens, error) {
+	var result *lsp.SemanticTokens
+	err := c.Call("textDocument/semanticTokens/range", lspserv.SemanticTokensRangeParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Range: r}, &result)
+	return result, err
+}
+
+func (c *Client) WorkspaceSymbols(query string) ([]lspserv.WorkspaceSymbol, error) {
+	var result []lspserv.WorkspaceSymbol
+	err := c.Call("workspace/symbol", lsp.WorkspaceSymbolParams{Query: query}, &result)
+	return result, err
+}
+
+// ExecuteCommand executes a command with arguments and decodes its result into result.
+func (c *Client) ExecuteCommand(command string, result interface{}, arguments ...interface{}) error {
+	return c.Call("workspace/executeCommand", lsp.ExecuteCommandParams{Command: command, Arguments: arguments}, result)
+}
+
+func (c *Client) FoldingRanges(uri lsp.DocumentURI) ([]lspserv.FoldingRange, error) {
+	var result []lspserv.FoldingRange
+	err := c.Call("textDocument/foldingRange", lspserv.FoldingRangeParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}, &result)
+	return result, err
+}
+
+func (c *Client) SelectionRanges(uri lsp.DocumentURI, positions ...lsp.Position) ([]*lspserv.SelectionRange, error) {
+	var result []*lspserv.SelectionRange
+	err := c.Call("textDocument/selectionRange", lspserv.SelectionRangeParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Positions: positions}, &result)
+	return result, err
+}
+
+func (c *Client) PrepareCallHierarchy(uri lsp.DocumentURI, position lsp.Position) ([]lspserv.CallHierarchyItem, error) {
+	var result []lspserv.CallHierarchyItem
+	err := c.Call("textDocument/prepareCallHierarchy", positionParams(uri, position), &result)
+	return result, err
+}
+
+func (c *Client) IncomingCalls(item lspserv.CallHierarchyItem) ([]lspserv.CallHierarchyIncomingCall, error) {
+	var result []lspserv.CallHierarchyIncomingCall
+	err := c.Call("callHierarchy/incomingCalls", lspserv.CallHierarchyIncomingCallsParams{Item: item}, &result)
+	return result, err
+}
+
+func (c *Client) OutgoingCalls(item lspserv.CallHierarchyItem) ([]lspserv.CallHierarchyOutgoingCall, error) {
+	var result []lspserv.CallHierarchyOutgoingCall
+	err := c.Call("callHierarchy/outgoingCalls", lspserv.CallHierarchyOutgoingCallsParams{Item: item}, &result)
+	return result, err
+}
+
+func (c *Client) PrepareTypeHierarchy(uri lsp.DocumentURI, position lsp.Position) ([]lspserv.TypeHierarchyItem, error) {
+	var result []lspserv.TypeHierarchyItem
+	err := c.Call("textDocument/prepareTypeHierarchy", positionParams(uri, position), &result)
+	return result, err
+}
+
+func (c *Client) Supertypes(item lspserv.TypeHierarchyItem) ([]lspserv.TypeHierarchyItem, error) {
+	var result []lspserv.TypeHierarchyItem
+	err := c.Call("typeHierarchy/supertypes", lspserv.TypeHierarchySupertypesParams{Item: item}, &result)
+	return result, err
+}
+
+func (c *Client) Subtypes(item lspserv.TypeHierarchyItem) ([]lspserv.TypeHierarchyItem, error) {
+	var result []lspserv.TypeHierarchyItem
+	err := c.Call("typeHierarchy/subtypes", lspserv.TypeHierarchySubtypesParams{Item: item}, &result)
+	return result, err
+}
+
+func (c *Client) InlayHints(uri lsp.DocumentURI, r lsp.Range) ([]lspserv.InlayHint, error) {
+	var result []lspserv.InlayHint
+	err := c.Call("textDocument/inlayHint", lspserv.InlayHintParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, Range: r}, &result)
+	return result, err
+}
+
+func (c *Client) ResolveInlayHint(hint lspserv.InlayHint) (*lspserv.InlayHint, error) {
+	var result *lspserv.InlayHint
+	err := c.Call("inlayHint/resolve", hint, &result)
+	return result, err
+}
+
+// DocumentDiagnostic pulls the diagnostics of the document. An unchanged report has the kind
+// lspserv.DiagnosticReportUnchanged and no items.
+func (c *Client) DocumentDiagnostic(uri lsp.DocumentURI, previousResultID string) (*lspserv.FullDocumentDiagnosticReport, error) {
+	var result *lspserv.FullDocumentDiagnosticReport
+	err := c.Call("textDocument/diagnostic", lspserv.DocumentDiagnosticParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}, PreviousResultID: previousResultID}, &result)
+	return result, err
+}
+
+func (c *Client) WorkspaceDiagnostic(params lspserv.WorkspaceDiagnosticParams) (*lspserv.WorkspaceDiagnosticReport, error) {
+	var result *lspserv.WorkspaceDiagnosticReport
+	err := c.Call("workspace/diagnostic", params, &result)
+	return result, err
+}
@@ -0,0 +1,129 @@
+package lspservtest_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+// documentHandler keeps the text of open documents, hovers with the text of the line and publishes a
+// diagnostic for every open or change. The other methods of lspserv.Handler panic.
+type documentHandler struct {
+	lspserv.Handler
+
+	mu        sync.Mutex
+	documents map[lsp.DocumentURI]string
+}
+
+func newDocumentHandler() *documentHandler {
+	return &documentHandler{documents: make(map[lsp.DocumentURI]string)}
+}
+
+func (h *documentHandler) Reset() error {
+	return nil
+}
+
+func (h *documentHandler) ShutDown() {
+}
+
+func (h *documentHandler) setText(uri lsp.DocumentURI, version int, text string, conn lspserv.Connection) error {
+	h.mu.Lock()
+	h.documents[uri] = text
+	h.mu.Unlock()
+
+	return conn.PublishDiagnostics(lsp.PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     uint(version),
+		Diagnostics: []lsp.Diagnostic{{Message: text}},
+	})
+}
+
+func (h *documentHandler) HandleDidOpen(params lsp.DidOpenTextDocumentParams, conn lspserv.Connection) error {
+	return h.setText(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text, conn)
+}
+
+func (h *documentHandler) HandleDidChange(params lsp.DidChangeTextDocumentParams, conn lspserv.Connection) error {
+	return h.setText(params.TextDocument.URI, params.TextDocument.Version, params.ContentChanges[0].Text, conn)
+}
+
+func (h *documentHandler) HandleDidClose(params lsp.DidCloseTextDocumentParams, conn lspserv.Connection) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.documents, params.TextDocument.URI)
+	return nil
+}
+
+func (h *documentHandler) HandleHover(params lsp.TextDocumentPositionParams, conn lspserv.Connection) (*lsp.Hover, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	text, isOpen := h.documents[params.TextDocument.URI]
+	if !isOpen {
+		return nil, nil
+	}
+
+	return &lsp.Hover{Contents: lsp.MarkupContent{Kind: "plaintext", Value: text}}, nil
+}
+
+func TestClientSession(t *testing.T) {
+	const uri = lsp.DocumentURI("file:///main.swamp")
+
+	client := lspservtest.NewClient(newDocumentHandler())
+
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.OpenDocument(uri, "swamp", "a = 2"); err != nil {
+		t.Fatal(err)
+	}
+	diagnostics, err := client.AwaitDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics.Version != 1 || len(diagnostics.Diagnostics) != 1 || diagnostics.Diagnostics[0].Message != "a = 2" {
+		t.Errorf("unexpected diagnostics after open: %+v", diagnostics)
+	}
+
+	if err := client.ChangeDocument(uri, "a = 3"); err != nil {
+		t.Fatal(err)
+	}
+	diagnostics, err = client.AwaitDiagnostics(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics.Version != 2 {
+		t.Errorf("expected version 2 after the change, got %d", diagnostics.Version)
+	}
+
+	hover, err := client.Hover(uri, lsp.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hover == nil || hover.Contents.Value != "a = 3" {
+		t.Errorf("expected the hover to have the changed text, got %+v", hover)
+	}
+
+	if err := client.CloseDocument(uri); err != nil {
+		t.Fatal(err)
+	}
+	hover, err = client.Hover(uri, lsp.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hover != nil {
+		t.Errorf("expected no hover after close, got %+v", hover)
+	}
+
+	if err := client.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("expected the service to stop cleanly, got %v", err)
+	}
+}
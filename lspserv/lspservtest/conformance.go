@@ -0,0 +1,617 @@
+package lspservtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+// ConformanceFailure is a check where the dispatcher does not behave as the LSP specification requires.
+type ConformanceFailure struct {
+	Check   string
+	Message string
+}
+
+func (f ConformanceFailure) String() string {
+	return fmt.Sprintf("%s: %s", f.Check, f.Message)
+}
+
+type conformanceCheck struct {
+	name string
+	run  func(s *conformanceSession) error
+	// countDidOpen wraps the handler in a didOpenCounter for the session.
+	countDidOpen bool
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"request before initialize returns ServerNotInitialized", checkRequestBeforeInitialize, false},
+	{"notification before initialize is dropped", checkNotificationBeforeInitialize, true},
+	{"double initialize is rejected", checkDoubleInitialize, false},
+	{"request after shutdown returns InvalidRequest", checkRequestAfterShutdown, false},
+	{"notification after shutdown is dropped", checkNotificationAfterShutdown, true},
+	{"exit before initialize stops with an error", checkExitBeforeInitialize, false},
+	{"exit without shutdown stops with an error", checkExitWithoutShutdown, false},
+	{"exit after shutdown stops cleanly", checkExitAfterShutdown, false},
+	{"unknown request returns MethodNotFound", checkUnknownRequest, false},
+	{"notifications never produce responses", checkNotificationsHaveNoResponse, false},
+	{"$/ notifications are silently ignored", checkDollarNotificationsIgnored, false},
+	{"missing params return InvalidParams", checkMissingParams, false},
+	{"malformed params return InvalidParams", checkMalformedParams, false},
+	{"advertised capabilities are routed", checkAdvertisedCapabilitiesRouted, false},
+}
+
+// RunConformance runs every check against a new Service built from a handler returned by newHandler and
+// returns the checks that failed. It can be called from a Go test:
+//
+//	for _, failure := range lspservtest.RunConformance(func() lspserv.Handler { return &MyHandler{} }) {
+//		t.Error(failure)
+//	}
+func RunConformance(newHandler func() lspserv.Handler) []ConformanceFailure {
+	var failures []ConformanceFailure
+
+	for _, check := range conformanceChecks {
+		handler := newHandler()
+		var counter *didOpenCounter
+		if check.countDidOpen {
+			counter = &didOpenCounter{Handler: handler}
+			handler = counter
+		}
+
+		session := newConformanceSession(handler)
+		session.didOpen = counter
+		err := check.run(session)
+		session.close()
+		if err != nil {
+			failures = append(failures, ConformanceFailure{Check: check.name, Message: err.Error()})
+		}
+	}
+
+	return failures
+}
+
+type rawResponse struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Result *json.RawMessage `json:"result"`
+	Error  *jsonrpc2.Error  `json:"error"`
+}
+
+// didOpenCounter counts the `textDocument/didOpen` notifications that reach the handler. It hides the optional
+// interfaces of the handler, so only the checks that need it use it.
+type didOpenCounter struct {
+	lspserv.Handler
+	count int32
+}
+
+func (h *didOpenCounter) HandleDidOpen(params lsp.DidOpenTextDocumentParams, conn lspserv.Connection) error {
+	atomic.AddInt32(&h.count, 1)
+	return h.Handler.HandleDidOpen(params, conn)
+}
+
+func (h *didOpenCounter) reached() error {
+	if count := atomic.LoadInt32(&h.count); count != 0 {
+		return fmt.Errorf("expected the notification to be dropped, but it reached the handler %d time(s)", count)
+	}
+	return nil
+}
+
+// capturingLogger keeps all lines at warning level or above.
+type capturingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *capturingLogger) Log(level lspserv.LogLevel, message string, fields ...lspserv.LogField) {
+	if level < lspserv.LogWarn {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(message)
+	for _, field := range fields {
+		fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, b.String())
+}
+
+func (l *capturingLogger) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines
+	l.lines = nil
+	return lines
+}
+
+// conformanceSession talks to the service on the message level, so it can send malformed messages and
+// observe responses that should not have been sent.
+type conformanceSession struct {
+	stream     jsonrpc2.ObjectStream
+	clientSide net.Conn
+	serverDone chan error
+	stopped    bool
+	logger     *capturingLogger
+	didOpen    *didOpenCounter
+	responses  chan rawResponse
+	nextID     int
+	timeout    time.Duration
+}
+
+func newConformanceSession(handler lspserv.Handler) *conformanceSession {
+	serverSide, clientSide := net.Pipe()
+
+	logger := &capturingLogger{}
+	service := lspserv.NewService(handler)
+	service.SetLogger(logger)
+
+	s := &conformanceSession{
+		stream:     jsonrpc2.NewBufferedStream(clientSide, jsonrpc2.VSCodeObjectCodec{}),
+		clientSide: clientSide,
+		serverDone: make(chan error, 1),
+		logger:     logger,
+		responses:  make(chan rawResponse, 64),
+		nextID:     1,
+		timeout:    DefaultTimeout,
+	}
+
+	go func() {
+		s.serverDone <- service.RunUntilClose(serverSide, false)
+	}()
+	go s.read()
+
+	return s
+}
+
+func (s *conformanceSession) read() {
+	defer close(s.responses)
+
+	for {
+		var message rawResponse
+		if err := s.stream.ReadObject(&message); err != nil {
+			return
+		}
+
+		switch {
+		case message.Method != "" && message.ID != nil:
+			// Written from another goroutine, since the server may be writing a response that is not read
+			// until this loop continues.
+			reply := map[string]interface{}{"jsonrpc": "2.0", "id": message.ID, "result": nil}
+			go s.stream.WriteObject(reply)
+		case message.Method != "":
+			// Notifications from the server, e.g. diagnostics, are allowed at any time.
+		default:
+			s.responses <- message
+		}
+	}
+}
+
+func (s *conformanceSession) close() {
+	s.clientSide.Close()
+	if s.stopped {
+		return
+	}
+	select {
+	case <-s.serverDone:
+	case <-time.After(s.timeout):
+	}
+}
+
+// request sends a request with raw params, which are omitted when nil, and returns its response. Responses to
+// any other ID are reported as errors.
+func (s *conformanceSession) request(method string, params json.RawMessage) (rawResponse, error) {
+	id := s.nextID
+	s.nextID++
+
+	message := map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method}
+	if params != nil {
+		message["params"] = params
+	}
+
+	if err := s.stream.WriteObject(message); err != nil {
+		return rawResponse{}, err
+	}
+
+	deadline := time.After(s.timeout)
+	for {
+		select {
+		case response, isOpen := <-s.responses:
+			if !isOpen {
+				return rawResponse{}, fmt.Errorf("connection closed while waiting for %s", method)
+			}
+			if response.ID == nil || string(*response.ID) != fmt.Sprint(id) {
+				return rawResponse{}, fmt.Errorf("unexpected response %s while waiting for %s", idString(response.ID), method)
+			}
+			return response, nil
+		case <-deadline:
+			return rawResponse{}, fmt.Errorf("no response to %s within %v", method, s.timeout)
+		}
+	}
+}
+
+// awaitStop waits for RunUntilClose to return on its own, e.g. after `exit`, and returns its result.
+func (s *conformanceSession) awaitStop() (serviceErr error, err error) {
+	select {
+	case serviceErr = <-s.serverDone:
+		s.stopped = true
+		return serviceErr, nil
+	case <-time.After(s.timeout):
+		return nil, fmt.Errorf("service did not stop within %v", s.timeout)
+	}
+}
+
+func (s *conformanceSession) notify(method string, params json.RawMessage) error {
+	message := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		message["params"] = params
+	}
+
+	return s.stream.WriteObject(message)
+}
+
+func (s *conformanceSession) initialize() error {
+	response, err := s.request("initialize", json.RawMessage(`{"capabilities":{}}`))
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("initialize failed: %v", response.Error)
+	}
+
+	return s.notify("initialized", json.RawMessage(`{}`))
+}
+
+func idString(id *json.RawMessage) string {
+	if id == nil {
+		return "<none>"
+	}
+	return string(*id)
+}
+
+func expectErrorCode(method string, response rawResponse, code int64) error {
+	if response.Error == nil {
+		return fmt.Errorf("%s: expected error code %d, got result %s", method, code, idString(response.Result))
+	}
+	if response.Error.Code != code {
+		return fmt.Errorf("%s: expected error code %d, got %d (%s)", method, code, response.Error.Code, response.Error.Message)
+	}
+	return nil
+}
+
+const hoverParams = `{"textDocument":{"uri":"file:///conformance.txt"},"position":{"line":0,"character":0}}`
+
+func checkRequestBeforeInitialize(s *conformanceSession) error {
+	response, err := s.request("textDocument/hover", json.RawMessage(hoverParams))
+	if err != nil {
+		return err
+	}
+
+	return expectErrorCode("textDocument/hover", response, lspserv.CodeServerNotInitialized)
+}
+
+func checkNotificationBeforeInitialize(s *conformanceSession) error {
+	if err := s.notify("textDocument/didOpen", json.RawMessage(`{"textDocument":{"uri":"file:///conformance.txt","languageId":"","version":1,"text":""}}`)); err != nil {
+		return err
+	}
+
+	// The notification is handled before initialize, so it has reached the handler by now if it was not dropped.
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	return s.didOpen.reached()
+}
+
+func checkDoubleInitialize(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	response, err := s.request("initialize", json.RawMessage(`{"capabilities":{}}`))
+	if err != nil {
+		return err
+	}
+	if response.Error == nil {
+		return fmt.Errorf("expected the second initialize to fail")
+	}
+
+	return nil
+}
+
+func checkRequestAfterShutdown(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	response, err := s.request("shutdown", nil)
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("shutdown failed: %v", response.Error)
+	}
+
+	response, err = s.request("textDocument/hover", json.RawMessage(hoverParams))
+	if err != nil {
+		return err
+	}
+
+	return expectErrorCode("textDocument/hover", response, jsonrpc2.CodeInvalidRequest)
+}
+
+func checkNotificationAfterShutdown(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	if _, err := s.request("shutdown", nil); err != nil {
+		return err
+	}
+
+	if err := s.notify("textDocument/didOpen", json.RawMessage(`{"textDocument":{"uri":"file:///conformance.txt","languageId":"","version":1,"text":""}}`)); err != nil {
+		return err
+	}
+
+	// Wait until the notification has been handled.
+	if _, err := s.request("textDocument/hover", json.RawMessage(hoverParams)); err != nil {
+		return err
+	}
+
+	return s.didOpen.reached()
+}
+
+func expectStop(s *conformanceSession, expected error) error {
+	serviceErr, err := s.awaitStop()
+	if err != nil {
+		return err
+	}
+	if serviceErr != expected {
+		return fmt.Errorf("expected RunUntilClose to return %v, got %v", expected, serviceErr)
+	}
+	return nil
+}
+
+func checkExitBeforeInitialize(s *conformanceSession) error {
+	if err := s.notify("exit", nil); err != nil {
+		return err
+	}
+
+	return expectStop(s, lspserv.ErrExitWithoutShutdown)
+}
+
+func checkExitWithoutShutdown(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	if err := s.notify("exit", nil); err != nil {
+		return err
+	}
+
+	return expectStop(s, lspserv.ErrExitWithoutShutdown)
+}
+
+func checkExitAfterShutdown(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	response, err := s.request("shutdown", nil)
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("shutdown failed: %v", response.Error)
+	}
+
+	if err := s.notify("exit", nil); err != nil {
+		return err
+	}
+
+	return expectStop(s, nil)
+}
+
+func checkUnknownRequest(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	response, err := s.request("conformance/unknownRequest", json.RawMessage(`{}`))
+	if err != nil {
+		return err
+	}
+
+	return expectErrorCode("conformance/unknownRequest", response, jsonrpc2.CodeMethodNotFound)
+}
+
+func checkNotificationsHaveNoResponse(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	notifications := []struct {
+		method string
+		params string
+	}{
+		{"textDocument/didOpen", `{"textDocument":{"uri":"file:///conformance.txt","languageId":"","version":1,"text":""}}`},
+		{"textDocument/didChange", `{"textDocument":{"uri":"file:///conformance.txt","version":2},"contentChanges":[{"text":"a"}]}`},
+		{"textDocument/didSave", `{"textDocument":{"uri":"file:///conformance.txt"}}`},
+		{"textDocument/didClose", `{"textDocument":{"uri":"file:///conformance.txt"}}`},
+		{"workspace/didChangeWatchedFiles", `{"changes":[]}`},
+		{"conformance/unknownNotification", `{}`},
+		{"$/unknownNotification", `{}`},
+		{"textDocument/didOpen", `{"textDocument":5}`},
+	}
+
+	for _, notification := range notifications {
+		if err := s.notify(notification.method, json.RawMessage(notification.params)); err != nil {
+			return err
+		}
+	}
+
+	// The requests are handled in order, so any response to the notifications arrives before this one.
+	response, err := s.request("conformance/unknownRequest", json.RawMessage(`{}`))
+	if err != nil {
+		return err
+	}
+
+	return expectErrorCode("conformance/unknownRequest", response, jsonrpc2.CodeMethodNotFound)
+}
+
+func checkDollarNotificationsIgnored(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	if err := s.notify("$/conformanceNotification", json.RawMessage(`{"value":1}`)); err != nil {
+		return err
+	}
+
+	// Wait until the notification has been handled.
+	if _, err := s.request("conformance/unknownRequest", json.RawMessage(`{}`)); err != nil {
+		return err
+	}
+
+	for _, line := range s.logger.take() {
+		if strings.Contains(line, "$/conformanceNotification") {
+			return fmt.Errorf("expected the notification to be ignored, but it was logged: %s", line)
+		}
+	}
+
+	return nil
+}
+
+func checkMissingParams(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	response, err := s.request("textDocument/hover", nil)
+	if err != nil {
+		return err
+	}
+
+	return expectErrorCode("textDocument/hover", response, jsonrpc2.CodeInvalidParams)
+}
+
+func checkMalformedParams(s *conformanceSession) error {
+	if err := s.initialize(); err != nil {
+		return err
+	}
+
+	for _, params := range []string{`{"textDocument":5}`, `{"textDocument":{"uri":"file:///conformance.txt"},"position":"start"}`, `[1,2]`} {
+		response, err := s.request("textDocument/hover", json.RawMessage(params))
+		if err != nil {
+			return err
+		}
+		if err := expectErrorCode("textDocument/hover", response, jsonrpc2.CodeInvalidParams); err != nil {
+			return fmt.Errorf("params %s: %w", params, err)
+		}
+	}
+
+	return nil
+}
+
+// capabilityRoutes maps server capabilities to the request they promise to handle.
+var capabilityRoutes = []struct {
+	capability string
+	method     string
+}{
+	{"hoverProvider", "textDocument/hover"},
+	{"completionProvider", "textDocument/completion"},
+	{"signatureHelpProvider", "textDocument/signatureHelp"},
+	{"declarationProvider", "textDocument/declaration"},
+	{"definitionProvider", "textDocument/definition"},
+	{"typeDefinitionProvider", "textDocument/typeDefinition"},
+	{"implementationProvider", "textDocument/implementation"},
+	{"referencesProvider", "textDocument/references"},
+	{"documentHighlightProvider", "textDocument/documentHighlight"},
+	{"documentSymbolProvider", "textDocument/documentSymbol"},
+	{"codeActionProvider", "textDocument/codeAction"},
+	{"codeLensProvider", "textDocument/codeLens"},
+	{"documentLinkProvider", "textDocument/documentLink"},
+	{"colorProvider", "textDocument/documentColor"},
+	{"documentFormattingProvider", "textDocument/formatting"},
+	{"documentRangeFormattingProvider", "textDocument/rangeFormatting"},
+	{"documentOnTypeFormattingProvider", "textDocument/onTypeFormatting"},
+	{"renameProvider", "textDocument/rename"},
+	{"foldingRangeProvider", "textDocument/foldingRange"},
+	{"executeCommandProvider", "workspace/executeCommand"},
+	{"selectionRangeProvider", "textDocument/selectionRange"},
+	{"linkedEditingRangeProvider", "textDocument/linkedEditingRange"},
+	{"callHierarchyProvider", "textDocument/prepareCallHierarchy"},
+	{"semanticTokensProvider", "textDocument/semanticTokens/full"},
+	{"semanticTokensProvider", "textDocument/semanticTokens/full/delta"},
+	{"semanticTokensProvider", "textDocument/semanticTokens/range"},
+	{"monikerProvider", "textDocument/moniker"},
+	{"typeHierarchyProvider", "textDocument/prepareTypeHierarchy"},
+	{"inlayHintProvider", "textDocument/inlayHint"},
+	{"diagnosticProvider", "textDocument/diagnostic"},
+	{"workspaceSymbolProvider", "workspace/symbol"},
+}
+
+// routeProbeParams has the fields of most text document requests, so the handler is reached.
+const routeProbeParams = `{
+	"textDocument": {"uri": "file:///conformance.txt"},
+	"position": {"line": 0, "character": 0},
+	"positions": [{"line": 0, "character": 0}],
+	"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 0}},
+	"context": {"includeDeclaration": true, "diagnostics": [], "triggerKind": 1},
+	"options": {"tabSize": 4, "insertSpaces": true},
+	"ch": "",
+	"newName": "conformance",
+	"command": "",
+	"query": ""
+}`
+
+func checkAdvertisedCapabilitiesRouted(s *conformanceSession) error {
+	response, err := s.request("initialize", json.RawMessage(`{"capabilities":{}}`))
+	if err != nil {
+		return err
+	}
+	if response.Error != nil || response.Result == nil {
+		return fmt.Errorf("initialize failed: %v", response.Error)
+	}
+	if err := s.notify("initialized", json.RawMessage(`{}`)); err != nil {
+		return err
+	}
+
+	var result struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	if err := json.Unmarshal(*response.Result, &result); err != nil {
+		return err
+	}
+
+	var unrouted []string
+	for _, route := range capabilityRoutes {
+		value, isAdvertised := result.Capabilities[route.capability]
+		if !isAdvertised || string(value) == "null" || string(value) == "false" {
+			continue
+		}
+
+		response, err := s.request(route.method, json.RawMessage(routeProbeParams))
+		if err != nil {
+			return err
+		}
+		if response.Error != nil && response.Error.Code == jsonrpc2.CodeMethodNotFound {
+			unrouted = append(unrouted, fmt.Sprintf("%s (%s)", route.capability, route.method))
+		}
+	}
+
+	if len(unrouted) > 0 {
+		return fmt.Errorf("advertised but not routed: %s", strings.Join(unrouted, ", "))
+	}
+
+	return nil
+}
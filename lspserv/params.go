@@ -0,0 +1,81 @@
+package lspserv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/piot/jsonrpc2"
+)
+
+// SetStrictParams rejects params with fields that the decoded type does not have, instead of ignoring them.
+// Useful for catching misspelled fields from clients under development, but fields newer than the go-lsp types
+// are rejected as well. Must be called before RunUntilClose.
+func (h *HandleLspRequests) SetStrictParams(strict bool) {
+	h.strictParams = strict
+}
+
+// decodeParams unmarshals the params of req into v. All failures are reported as InvalidParams
+// with a message naming the method and, if known, the field that failed.
+func (h *HandleLspRequests) decodeParams(req *jsonrpc2.Request, v interface{}) error {
+	return decodeParams(req.Method, rawParams(req), v, h.strictParams)
+}
+
+func rawParams(req *jsonrpc2.Request) json.RawMessage {
+	if req.Params == nil {
+		return nil
+	}
+
+	return *req.Params
+}
+
+func decodeParams(method string, params json.RawMessage, v interface{}, strict bool) error {
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("%s: missing params", method)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(v); err != nil {
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: fmt.Sprintf("%s: invalid params: %s", method, describeDecodeError(err))}
+	}
+
+	return nil
+}
+
+func describeDecodeError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return fmt.Sprintf("expected %v, got %s", typeErr.Type, typeErr.Value)
+		}
+		return fmt.Sprintf("field %q expected %v, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Sprintf("malformed JSON at offset %d: %v", syntaxErr.Offset, syntaxErr)
+	}
+
+	// Unknown fields in strict mode are reported as `json: unknown field "name"`.
+	return err.Error()
+}
+
+type decodeContextKey struct{}
+
+type decodeOptions struct {
+	method string
+	strict bool
+}
+
+// DecodeParams unmarshals the params of a custom method into v, the same way as the params of the methods of the
+// protocol itself, and reports a failure as an InvalidParams error.
+func DecodeParams(ctx context.Context, params json.RawMessage, v interface{}) error {
+	options, _ := ctx.Value(decodeContextKey{}).(decodeOptions)
+	return decodeParams(options.method, params, v, options.strict)
+}
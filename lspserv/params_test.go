@@ -0,0 +1,187 @@
+package lspserv_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/piot/go-lsp"
+	"github.com/piot/jsonrpc2"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+// routeHandler implements every optional handler interface, so that all the routes of the dispatcher are enabled.
+// The methods are never called by the tests using it.
+type routeHandler struct {
+	stubHandler
+}
+
+func (h *routeHandler) HandleFoldingRange(params lspserv.FoldingRangeParams, conn lspserv.Connection) ([]lspserv.FoldingRange, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleSelectionRange(params lspserv.SelectionRangeParams, conn lspserv.Connection) ([]*lspserv.SelectionRange, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandlePrepareCallHierarchy(params lsp.TextDocumentPositionParams, conn lspserv.Connection) ([]lspserv.CallHierarchyItem, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleCallHierarchyIncomingCalls(params lspserv.CallHierarchyIncomingCallsParams, conn lspserv.Connection) ([]lspserv.CallHierarchyIncomingCall, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleCallHierarchyOutgoingCalls(params lspserv.CallHierarchyOutgoingCallsParams, conn lspserv.Connection) ([]lspserv.CallHierarchyOutgoingCall, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandlePrepareTypeHierarchy(params lsp.TextDocumentPositionParams, conn lspserv.Connection) ([]lspserv.TypeHierarchyItem, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleTypeHierarchySupertypes(params lspserv.TypeHierarchySupertypesParams, conn lspserv.Connection) ([]lspserv.TypeHierarchyItem, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleTypeHierarchySubtypes(params lspserv.TypeHierarchySubtypesParams, conn lspserv.Connection) ([]lspserv.TypeHierarchyItem, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleInlayHint(params lspserv.InlayHintParams, conn lspserv.Connection) ([]lspserv.InlayHint, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleInlayHintResolve(hint lspserv.InlayHint, conn lspserv.Connection) (*lspserv.InlayHint, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleDocumentDiagnostic(params lspserv.DocumentDiagnosticParams, conn lspserv.Connection) ([]lsp.Diagnostic, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleWorkspaceDiagnostic(params lspserv.WorkspaceDiagnosticParams, reporter *lspserv.WorkspaceDiagnosticReporter, conn lspserv.Connection) error {
+	return nil
+}
+
+func (h *routeHandler) HandleWorkspaceSymbol(params lsp.WorkspaceSymbolParams, conn lspserv.Connection) ([]lspserv.WorkspaceSymbol, error) {
+	return nil, nil
+}
+
+func (h *routeHandler) HandleWorkspaceSymbolResolve(symbol lspserv.WorkspaceSymbol, conn lspserv.Connection) (*lspserv.WorkspaceSymbol, error) {
+	return nil, nil
+}
+
+const (
+	paramsDocument = `"textDocument":{"uri":"file:///main.swamp"}`
+	paramsPosition = paramsDocument + `,"position":{"line":0,"character":0}`
+	paramsRange    = `{"start":{"line":0,"character":0},"end":{"line":1,"character":0}}`
+	paramsItem     = `{"name":"main","kind":12,"uri":"file:///main.swamp","range":` + paramsRange + `,"selectionRange":` + paramsRange + `}`
+)
+
+// validParams has the params of a request or notification for every route, with the fields from the specification.
+var validParams = map[string]string{
+	"textDocument/didOpen":                   `{"textDocument":{"uri":"file:///main.swamp","languageId":"swamp","version":1,"text":"a = 2"}}`,
+	"textDocument/didChange":                 `{"textDocument":{"uri":"file:///main.swamp","version":2},"contentChanges":[{"range":` + paramsRange + `,"text":"b"}]}`,
+	"textDocument/didClose":                  `{` + paramsDocument + `}`,
+	"textDocument/willSave":                  `{` + paramsDocument + `,"reason":1}`,
+	"textDocument/didSave":                   `{` + paramsDocument + `,"text":"a = 2"}`,
+	"$/setTrace":                             `{"value":"off"}`,
+	"$/cancelRequest":                        `{"id":1}`,
+	"textDocument/hover":                     `{` + paramsPosition + `}`,
+	"textDocument/definition":                `{` + paramsPosition + `}`,
+	"textDocument/declaration":               `{` + paramsPosition + `}`,
+	"textDocument/typeDefinition":            `{` + paramsPosition + `}`,
+	"textDocument/implementation":            `{` + paramsPosition + `}`,
+	"textDocument/completion":                `{` + paramsPosition + `,"context":{"triggerKind":1}}`,
+	"completionItem/resolve":                 `{"label":"main"}`,
+	"textDocument/references":                `{` + paramsPosition + `,"context":{"includeDeclaration":true}}`,
+	"textDocument/documentSymbol":            `{` + paramsDocument + `}`,
+	"textDocument/linkedEditingRange":        `{` + paramsPosition + `}`,
+	"textDocument/semanticTokens/full":       `{` + paramsDocument + `}`,
+	"textDocument/semanticTokens/full/delta": `{` + paramsDocument + `,"previousResultId":"1"}`,
+	"textDocument/semanticTokens/range":      `{` + paramsDocument + `,"range":` + paramsRange + `}`,
+	"textDocument/signatureHelp":             `{` + paramsPosition + `}`,
+	"textDocument/formatting":                `{` + paramsDocument + `,"options":{"tabSize":4,"insertSpaces":true}}`,
+	"textDocument/codeAction":                `{` + paramsDocument + `,"range":` + paramsRange + `,"context":{"diagnostics":[]}}`,
+	"textDocument/documentHighlight":         `{` + paramsPosition + `}`,
+	"textDocument/codeLens":                  `{` + paramsDocument + `}`,
+	"textDocument/rename":                    `{` + paramsPosition + `,"newName":"other"}`,
+	"workspace/didChangeWatchedFiles":        `{"changes":[{"uri":"file:///main.swamp","type":2}]}`,
+	"textDocument/foldingRange":              `{` + paramsDocument + `}`,
+	"textDocument/selectionRange":            `{` + paramsDocument + `,"positions":[{"line":0,"character":0}]}`,
+	"textDocument/prepareCallHierarchy":      `{` + paramsPosition + `}`,
+	"callHierarchy/incomingCalls":            `{"item":` + paramsItem + `}`,
+	"callHierarchy/outgoingCalls":            `{"item":` + paramsItem + `}`,
+	"textDocument/prepareTypeHierarchy":      `{` + paramsPosition + `}`,
+	"typeHierarchy/supertypes":               `{"item":` + paramsItem + `}`,
+	"typeHierarchy/subtypes":                 `{"item":` + paramsItem + `}`,
+	"textDocument/inlayHint":                 `{` + paramsDocument + `,"range":` + paramsRange + `}`,
+	"inlayHint/resolve":                      `{"position":{"line":0,"character":1},"label":": int"}`,
+	"textDocument/diagnostic":                `{` + paramsDocument + `,"previousResultId":"1"}`,
+	"workspace/diagnostic":                   `{"previousResultIds":[{"uri":"file:///main.swamp","value":"1"}]}`,
+	"workspace/executeCommand":               `{"command":"swamp.build","arguments":[]}`,
+	"workspace/symbol":                       `{"query":"main"}`,
+	"workspaceSymbol/resolve":                `{"name":"main","kind":12,"location":{"uri":"file:///main.swamp"}}`,
+}
+
+// newStrictClient answers every request with decoded params from an interceptor, so only params that fail to
+// decode reach the end of the chain.
+func newStrictClient(t *testing.T) *lspservtest.Client {
+	t.Helper()
+
+	service := lspserv.NewService(&routeHandler{})
+	service.SetStrictParams(true)
+	service.RegisterCommand("swamp.build", func(ctx context.Context, arguments []json.RawMessage) (interface{}, error) {
+		return nil, nil
+	})
+	service.AddInterceptor(func(ctx context.Context, call *lspserv.Call, next func() (interface{}, error)) (interface{}, error) {
+		if _, isRaw := call.Params.(*json.RawMessage); isRaw {
+			return next()
+		}
+		return nil, nil
+	})
+
+	client := lspservtest.NewServiceClient(service)
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestStrictParamsAcceptValidParams(t *testing.T) {
+	client := newStrictClient(t)
+	defer client.Close()
+
+	// Notifications are sent as requests as well, so that a failure to decode is replied.
+	for method, params := range validParams {
+		if err := client.Call(method, json.RawMessage(params), nil); err != nil {
+			t.Errorf("%s: %v", method, err)
+		}
+	}
+}
+
+func TestStrictParamsNameTheField(t *testing.T) {
+	client := newStrictClient(t)
+	defer client.Close()
+
+	for _, test := range []struct {
+		name   string
+		params string
+		field  string
+	}{
+		{"unknown field", `{` + paramsPosition + `,"positon":{"line":0,"character":0}}`, `"positon"`},
+		{"wrong type", `{"textDocument":5,"position":{"line":0,"character":0}}`, `"textDocument"`},
+		{"wrong nested type", `{` + paramsDocument + `,"position":{"line":"0","character":0}}`, `"position.line"`},
+	} {
+		err := client.Call("textDocument/hover", json.RawMessage(test.params), nil)
+		expectCode(t, err, jsonrpc2.CodeInvalidParams)
+		if err != nil && !strings.Contains(err.Error(), test.field) {
+			t.Errorf("%s: expected the error to name %s, got %v", test.name, test.field, err)
+		}
+	}
+}
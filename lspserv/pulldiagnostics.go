@@ -0,0 +1,199 @@
+package lspserv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/piot/go-lsp"
+)
+
+type DocumentDiagnosticReportKind string
+
+const (
+	DiagnosticReportFull      DocumentDiagnosticReportKind = "full"
+	DiagnosticReportUnchanged DocumentDiagnosticReportKind = "unchanged"
+)
+
+type DocumentDiagnosticParams struct {
+	TextDocument     lsp.TextDocumentIdentifier `json:"textDocument"`
+	Identifier       string                     `json:"identifier,omitempty"`
+	PreviousResultID string                     `json:"previousResultId,omitempty"`
+}
+
+type FullDocumentDiagnosticReport struct {
+	Kind     DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                       `json:"resultId,omitempty"`
+	Items    []lsp.Diagnostic             `json:"items"`
+}
+
+// UnchangedDocumentDiagnosticReport tells the client to keep the diagnostics it got with the same result ID.
+type UnchangedDocumentDiagnosticReport struct {
+	Kind     DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                       `json:"resultId"`
+}
+
+type PreviousResultID struct {
+	URI   lsp.DocumentURI `json:"uri"`
+	Value string          `json:"value"`
+}
+
+type WorkspaceDiagnosticParams struct {
+	Identifier        string             `json:"identifier,omitempty"`
+	PreviousResultIDs []PreviousResultID `json:"previousResultIds"`
+	// PartialResultToken is set by clients that want the reports streamed with `$/progress`.
+	PartialResultToken json.RawMessage `json:"partialResultToken,omitempty"`
+}
+
+type WorkspaceFullDocumentDiagnosticReport struct {
+	FullDocumentDiagnosticReport
+	URI     lsp.DocumentURI `json:"uri"`
+	Version *int            `json:"version"`
+}
+
+type WorkspaceUnchangedDocumentDiagnosticReport struct {
+	UnchangedDocumentDiagnosticReport
+	URI     lsp.DocumentURI `json:"uri"`
+	Version *int            `json:"version"`
+}
+
+// WorkspaceDiagnosticReport has items of WorkspaceFullDocumentDiagnosticReport and
+// WorkspaceUnchangedDocumentDiagnosticReport.
+type WorkspaceDiagnosticReport struct {
+	Items []interface{} `json:"items"`
+}
+
+type DiagnosticOptions struct {
+	lsp.WorkDoneProgressOptions
+	Identifier            string `json:"identifier,omitempty"`
+	InterFileDependencies bool   `json:"interFileDependencies"`
+	WorkspaceDiagnostics  bool   `json:"workspaceDiagnostics"`
+}
+
+// DocumentDiagnosticHandler can optionally be implemented by a Handler to serve `textDocument/diagnostic`, so
+// the client pulls the diagnostics when it needs them instead of the handler pushing them with
+// PublishDiagnostics. The dispatcher derives the result ID from the diagnostics and replies with an unchanged
+// report when they are the same as the ones the client already has.
+type DocumentDiagnosticHandler interface {
+	HandleDocumentDiagnostic(params DocumentDiagnosticParams, conn Connection) ([]lsp.Diagnostic, error)
+}
+
+// WorkspaceDiagnosticHandler serves `workspace/diagnostic` by reporting the diagnostics of every document,
+// including the ones that are not open. It is only advertised together with DocumentDiagnosticHandler.
+type WorkspaceDiagnosticHandler interface {
+	HandleWorkspaceDiagnostic(params WorkspaceDiagnosticParams, reporter *WorkspaceDiagnosticReporter, conn Connection) error
+}
+
+func (h *HandleLspRequests) diagnosticProvider() *DiagnosticOptions {
+	if _, ok := h.handler.(DocumentDiagnosticHandler); !ok {
+		return nil
+	}
+
+	_, hasWorkspace := h.handler.(WorkspaceDiagnosticHandler)
+
+	// Assume that documents depend on each other, so the client pulls the diagnostics of the other open
+	// documents after a change.
+	return &DiagnosticOptions{InterFileDependencies: true, WorkspaceDiagnostics: hasWorkspace}
+}
+
+// diagnosticsResultID identifies a set of diagnostics by its content, so equal diagnostics get the same ID.
+func diagnosticsResultID(diagnostics []lsp.Diagnostic) string {
+	octets, _ := json.Marshal(diagnostics)
+	sum := sha256.Sum256(octets)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (h *HandleLspRequests) handleDocumentDiagnostic(diagnosticHandler DocumentDiagnosticHandler, params DocumentDiagnosticParams, conn Connection) (interface{}, error) {
+	diagnostics, err := diagnosticHandler.HandleDocumentDiagnostic(params, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if diagnostics == nil {
+		diagnostics = []lsp.Diagnostic{}
+	}
+
+	resultID := diagnosticsResultID(diagnostics)
+	if resultID == params.PreviousResultID {
+		return UnchangedDocumentDiagnosticReport{Kind: DiagnosticReportUnchanged, ResultID: resultID}, nil
+	}
+
+	return FullDocumentDiagnosticReport{Kind: DiagnosticReportFull, ResultID: resultID, Items: diagnostics}, nil
+}
+
+// WorkspaceDiagnosticReporter collects the reports of a `workspace/diagnostic` request. When the client asked
+// for partial results, every report is sent right away with `$/progress`. It is safe for concurrent use.
+type WorkspaceDiagnosticReporter struct {
+	mu           sync.Mutex
+	previous     map[lsp.DocumentURI]string
+	partialToken json.RawMessage
+	out          *SendOut
+	items        []interface{}
+}
+
+func newWorkspaceDiagnosticReporter(params WorkspaceDiagnosticParams, out *SendOut) *WorkspaceDiagnosticReporter {
+	previous := make(map[lsp.DocumentURI]string, len(params.PreviousResultIDs))
+	for _, id := range params.PreviousResultIDs {
+		previous[id.URI] = id.Value
+	}
+
+	return &WorkspaceDiagnosticReporter{previous: previous, partialToken: params.PartialResultToken, out: out}
+}
+
+// Report adds the diagnostics of a document. Version is the version of the open document they were computed
+// for, or nil for documents that are not open.
+func (r *WorkspaceDiagnosticReporter) Report(uri lsp.DocumentURI, version *int, diagnostics []lsp.Diagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []lsp.Diagnostic{}
+	}
+
+	var item interface{}
+	resultID := diagnosticsResultID(diagnostics)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.previous[uri] == resultID {
+		item = WorkspaceUnchangedDocumentDiagnosticReport{
+			UnchangedDocumentDiagnosticReport: UnchangedDocumentDiagnosticReport{Kind: DiagnosticReportUnchanged, ResultID: resultID},
+			URI:                               uri,
+			Version:                           version,
+		}
+	} else {
+		item = WorkspaceFullDocumentDiagnosticReport{
+			FullDocumentDiagnosticReport: FullDocumentDiagnosticReport{Kind: DiagnosticReportFull, ResultID: resultID, Items: diagnostics},
+			URI:                          uri,
+			Version:                      version,
+		}
+	}
+
+	if len(r.partialToken) == 0 {
+		r.items = append(r.items, item)
+		return nil
+	}
+
+	return r.out.progress(r.partialToken, WorkspaceDiagnosticReport{Items: []interface{}{item}})
+}
+
+// result is the reply to the request. Reports already sent as partial results are not repeated.
+func (r *WorkspaceDiagnosticReporter) result() WorkspaceDiagnosticReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.items == nil {
+		return WorkspaceDiagnosticReport{Items: []interface{}{}}
+	}
+
+	return WorkspaceDiagnosticReport{Items: r.items}
+}
+
+type progressParams struct {
+	Token json.RawMessage `json:"token"`
+	Value interface{}     `json:"value"`
+}
+
+// progress sends `$/progress`, e.g. with a partial result.
+func (s *SendOut) progress(token json.RawMessage, value interface{}) error {
+	return s.conn.Notify(s.ctx, "$/progress", progressParams{Token: token, Value: value})
+}
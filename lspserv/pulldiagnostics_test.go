@@ -0,0 +1,167 @@
+package lspserv_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+	"github.com/piot/lsp-server/lspserv/lspservtest"
+)
+
+// pullHandler reports the diagnostics set for each document, in the order of uris.
+type pullHandler struct {
+	stubHandler
+
+	uris        []lsp.DocumentURI
+	diagnostics map[lsp.DocumentURI][]lsp.Diagnostic
+}
+
+func newPullHandler() *pullHandler {
+	return &pullHandler{
+		uris: []lsp.DocumentURI{"file:///a.swamp", "file:///b.swamp"},
+		diagnostics: map[lsp.DocumentURI][]lsp.Diagnostic{
+			"file:///a.swamp": {{Message: "unused a"}},
+			"file:///b.swamp": {},
+		},
+	}
+}
+
+func (h *pullHandler) HandleDocumentDiagnostic(params lspserv.DocumentDiagnosticParams, conn lspserv.Connection) ([]lsp.Diagnostic, error) {
+	return h.diagnostics[params.TextDocument.URI], nil
+}
+
+func (h *pullHandler) HandleWorkspaceDiagnostic(params lspserv.WorkspaceDiagnosticParams, reporter *lspserv.WorkspaceDiagnosticReporter, conn lspserv.Connection) error {
+	for _, uri := range h.uris {
+		if err := reporter.Report(uri, nil, h.diagnostics[uri]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newPullClient(t *testing.T, handler *pullHandler) *lspservtest.Client {
+	t.Helper()
+
+	client := lspservtest.NewClient(handler)
+	if _, err := client.Initialize(lsp.InitializeParams{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestDocumentDiagnosticResultID(t *testing.T) {
+	const uri = lsp.DocumentURI("file:///a.swamp")
+
+	handler := newPullHandler()
+	client := newPullClient(t, handler)
+	defer client.Close()
+
+	first, err := client.DocumentDiagnostic(uri, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Kind != lspserv.DiagnosticReportFull || first.ResultID == "" || len(first.Items) != 1 {
+		t.Fatalf("expected a full report with a result ID, got %+v", first)
+	}
+
+	unchanged, err := client.DocumentDiagnostic(uri, first.ResultID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.Kind != lspserv.DiagnosticReportUnchanged || unchanged.ResultID != first.ResultID || len(unchanged.Items) != 0 {
+		t.Errorf("expected an unchanged report with the same result ID, got %+v", unchanged)
+	}
+
+	handler.diagnostics[uri] = []lsp.Diagnostic{{Message: "unused b"}}
+
+	changed, err := client.DocumentDiagnostic(uri, first.ResultID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Kind != lspserv.DiagnosticReportFull || changed.ResultID == first.ResultID || len(changed.Items) != 1 || changed.Items[0].Message != "unused b" {
+		t.Errorf("expected a full report with a new result ID, got %+v", changed)
+	}
+}
+
+type workspaceItem struct {
+	Kind     lspserv.DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                               `json:"resultId"`
+	URI      lsp.DocumentURI                      `json:"uri"`
+}
+
+func decodeWorkspaceItems(t *testing.T, items []interface{}) []workspaceItem {
+	t.Helper()
+
+	octets, err := json.Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []workspaceItem
+	if err := json.Unmarshal(octets, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestWorkspaceDiagnosticResultID(t *testing.T) {
+	client := newPullClient(t, newPullHandler())
+	defer client.Close()
+
+	report, err := client.WorkspaceDiagnostic(lspserv.WorkspaceDiagnosticParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := decodeWorkspaceItems(t, report.Items)
+	if len(full) != 2 || full[0].Kind != lspserv.DiagnosticReportFull || full[1].Kind != lspserv.DiagnosticReportFull {
+		t.Fatalf("expected full reports of both documents, got %+v", full)
+	}
+
+	report, err = client.WorkspaceDiagnostic(lspserv.WorkspaceDiagnosticParams{
+		PreviousResultIDs: []lspserv.PreviousResultID{{URI: full[0].URI, Value: full[0].ResultID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := decodeWorkspaceItems(t, report.Items)
+	if len(items) != 2 || items[0].Kind != lspserv.DiagnosticReportUnchanged || items[0].ResultID != full[0].ResultID || items[1].Kind != lspserv.DiagnosticReportFull {
+		t.Errorf("expected the first document to be unchanged, got %+v", items)
+	}
+}
+
+func TestWorkspaceDiagnosticPartialResults(t *testing.T) {
+	client := newPullClient(t, newPullHandler())
+	defer client.Close()
+
+	report, err := client.WorkspaceDiagnostic(lspserv.WorkspaceDiagnosticParams{PartialResultToken: json.RawMessage(`"partial"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Items) != 0 {
+		t.Errorf("expected the reports to only be sent as partial results, got %+v", report.Items)
+	}
+
+	for _, expected := range []lsp.DocumentURI{"file:///a.swamp", "file:///b.swamp"} {
+		raw, err := client.AwaitNotification("$/progress")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var progress struct {
+			Token string                            `json:"token"`
+			Value lspserv.WorkspaceDiagnosticReport `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &progress); err != nil {
+			t.Fatal(err)
+		}
+
+		items := decodeWorkspaceItems(t, progress.Value.Items)
+		if progress.Token != "partial" || len(items) != 1 || items[0].URI != expected {
+			t.Errorf("expected the partial result of %s, got %s", expected, raw)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package lspserv
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/piot/jsonrpc2"
+)
+
+// RecordEnvironmentVariable names a file that every session is recorded to, unless a recorder is set on the Service.
+const RecordEnvironmentVariable = "LSPSERV_RECORD"
+
+type RecordDirection string
+
+const (
+	// RecordIncoming is a message sent from the client to the server.
+	RecordIncoming RecordDirection = "in"
+	// RecordOutgoing is a message sent from the server to the client.
+	RecordOutgoing RecordDirection = "out"
+)
+
+// RecordedMessage is one line in a recording.
+type RecordedMessage struct {
+	Time      time.Time       `json:"time"`
+	Direction RecordDirection `json:"direction"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// Recorder writes every JSON-RPC message of a session as JSON lines, which can be attached to bug reports.
+type Recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	err    error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// NewFileRecorder creates (or truncates) the file at path and records to it.
+func NewFileRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{w: file, closer: file}, nil
+}
+
+func (r *Recorder) connOpts() []jsonrpc2.ConnOpt {
+	return []jsonrpc2.ConnOpt{
+		jsonrpc2.OnRecv(func(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+			// The request is also passed along with an incoming response, so only record the response.
+			if resp != nil {
+				r.record(RecordIncoming, resp)
+			} else if req != nil {
+				r.record(RecordIncoming, req)
+			}
+		}),
+		jsonrpc2.OnSend(func(req *jsonrpc2.Request, resp *jsonrpc2.Response) {
+			if req != nil {
+				r.record(RecordOutgoing, req)
+			} else if resp != nil {
+				r.record(RecordOutgoing, resp)
+			}
+		}),
+	}
+}
+
+func (r *Recorder) record(direction RecordDirection, message interface{}) {
+	octets, err := json.Marshal(message)
+	if err != nil {
+		r.setErr(err)
+		return
+	}
+
+	line, err := json.Marshal(RecordedMessage{Time: time.Now(), Direction: direction, Message: octets})
+	if err != nil {
+		r.setErr(err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(line, '\n')); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *Recorder) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error that occurred while recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close closes the file of a recorder created with NewFileRecorder.
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+
+	return r.closer.Close()
+}
@@ -0,0 +1,64 @@
+package lspserv
+
+import (
+	"fmt"
+
+	"github.com/piot/go-lsp"
+)
+
+type SelectionRangeParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	Positions    []lsp.Position             `json:"positions"`
+}
+
+// SelectionRange is the range selected when expanding the selection, with Parent as the next expansion.
+type SelectionRange struct {
+	Range  lsp.Range       `json:"range"`
+	Parent *SelectionRange `json:"parent,omitempty"`
+}
+
+// SelectionRangeHandler can optionally be implemented by a Handler to serve `textDocument/selectionRange`.
+// It returns one SelectionRange for each of the requested positions, in the same order.
+type SelectionRangeHandler interface {
+	HandleSelectionRange(params SelectionRangeParams, conn Connection) ([]*SelectionRange, error)
+}
+
+func (h *HandleLspRequests) selectionRangeProvider() *lsp.SelectionRangeOptions {
+	if _, ok := h.handler.(SelectionRangeHandler); !ok {
+		return nil
+	}
+
+	return &lsp.SelectionRangeOptions{}
+}
+
+// NewSelectionRange builds the parent chain from ranges ordered from the innermost to the outermost, e.g. the
+// nodes of an AST path from the leaf to the root. Ranges equal to their child are skipped. It fails if a
+// range does not contain the one before it.
+func NewSelectionRange(ranges ...lsp.Range) (*SelectionRange, error) {
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("NewSelectionRange: no ranges")
+	}
+
+	root := &SelectionRange{Range: ranges[0]}
+	current := root
+	for _, r := range ranges[1:] {
+		if r == current.Range {
+			continue
+		}
+		if !rangeContains(r, current.Range) {
+			return nil, fmt.Errorf("NewSelectionRange: %v does not contain %v", r, current.Range)
+		}
+		current.Parent = &SelectionRange{Range: r}
+		current = current.Parent
+	}
+
+	return root, nil
+}
+
+func rangeContains(outer lsp.Range, inner lsp.Range) bool {
+	return !positionBefore(inner.Start, outer.Start) && !positionBefore(outer.End, inner.End)
+}
+
+func positionBefore(a lsp.Position, b lsp.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
@@ -0,0 +1,78 @@
+package lspserv_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+func lineRange(startLine int, startCharacter int, endLine int, endCharacter int) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{Line: startLine, Character: startCharacter},
+		End:   lsp.Position{Line: endLine, Character: endCharacter},
+	}
+}
+
+// formatSelectionRange writes the range and each parent as `line:character-line:character`, innermost first.
+func formatSelectionRange(selection *lspserv.SelectionRange) []string {
+	formatted := []string{}
+	for ; selection != nil; selection = selection.Parent {
+		r := selection.Range
+		formatted = append(formatted, fmt.Sprintf("%d:%d-%d:%d", r.Start.Line, r.Start.Character, r.End.Line, r.End.Character))
+	}
+	return formatted
+}
+
+func TestNewSelectionRange(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		ranges   []lsp.Range
+		expected []string
+	}{
+		{"single", []lsp.Range{lineRange(1, 4, 1, 8)}, []string{"1:4-1:8"}},
+		{
+			"chain",
+			[]lsp.Range{lineRange(1, 4, 1, 8), lineRange(1, 0, 1, 12), lineRange(0, 0, 3, 0)},
+			[]string{"1:4-1:8", "1:0-1:12", "0:0-3:0"},
+		},
+		{
+			"duplicate skipped",
+			[]lsp.Range{lineRange(1, 4, 1, 8), lineRange(1, 4, 1, 8), lineRange(0, 0, 3, 0)},
+			[]string{"1:4-1:8", "0:0-3:0"},
+		},
+		{
+			"shared start and end",
+			[]lsp.Range{lineRange(1, 4, 1, 8), lineRange(1, 4, 2, 0), lineRange(0, 0, 2, 0)},
+			[]string{"1:4-1:8", "1:4-2:0", "0:0-2:0"},
+		},
+	} {
+		selection, err := lspserv.NewSelectionRange(test.ranges...)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if actual := formatSelectionRange(selection); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestNewSelectionRangeErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		ranges []lsp.Range
+	}{
+		{"no ranges", nil},
+		{"parent ends before the child", []lsp.Range{lineRange(1, 4, 1, 8), lineRange(1, 0, 1, 6)}},
+		{"parent starts after the child", []lsp.Range{lineRange(1, 4, 1, 8), lineRange(1, 5, 2, 0)}},
+		{"outer parent does not contain", []lsp.Range{lineRange(1, 4, 1, 8), lineRange(1, 0, 1, 12), lineRange(1, 2, 3, 0)}},
+	} {
+		if selection, err := lspserv.NewSelectionRange(test.ranges...); err == nil {
+			t.Errorf("%s: expected an error, got %v", test.name, formatSelectionRange(selection))
+		}
+	}
+}
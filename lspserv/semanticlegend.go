@@ -0,0 +1,209 @@
+package lspserv
+
+import (
+	"fmt"
+
+	"github.com/piot/go-lsp"
+)
+
+// SemanticTokensLegendProvider can optionally be implemented by a Handler to declare its own token types and
+// modifiers, e.g. `lifetime` or `mutable`. Without it, the handler uses DefaultSemanticTokensLegend. Semantic
+// tokens returned by the handler are always encoded against its own legend, the dispatcher remaps them to the
+// legend advertised to the client.
+type SemanticTokensLegendProvider interface {
+	SemanticTokensLegend() lsp.SemanticTokensLegend
+}
+
+func (h *HandleLspRequests) handlerSemanticTokensLegend() lsp.SemanticTokensLegend {
+	provider, ok := h.handler.(SemanticTokensLegendProvider)
+	if !ok {
+		return DefaultSemanticTokensLegend
+	}
+
+	return provider.SemanticTokensLegend()
+}
+
+// semanticTokensLegend maps the legend of the handler to the legend advertised to the client, which only has
+// the token types and modifiers that the client declared.
+type semanticTokensLegend struct {
+	handler lsp.SemanticTokensLegend
+	client  lsp.SemanticTokensLegend
+	// types has the client index of each handler token type, or -1 if the client does not know the type.
+	types []int
+	// modifiers has the client bit of each handler token modifier, or 0 if the client does not know it.
+	modifiers []uint
+}
+
+// newSemanticTokensLegend intersects the legend of the handler with the token types and modifiers declared by
+// the client. A client that declares none is assumed to accept all of them.
+func newSemanticTokensLegend(handler lsp.SemanticTokensLegend, clientTypes []string, clientModifiers []string) (*semanticTokensLegend, error) {
+	if err := validateLegendNames("token type", handler.TokenTypes); err != nil {
+		return nil, err
+	}
+
+	if err := validateLegendNames("token modifier", handler.TokenModifiers); err != nil {
+		return nil, err
+	}
+
+	if len(handler.TokenModifiers) > 32 {
+		return nil, fmt.Errorf("semantic tokens legend: %d token modifiers do not fit in 32 bits", len(handler.TokenModifiers))
+	}
+
+	legend := &semanticTokensLegend{handler: handler}
+
+	var typeIndices []int
+	legend.client.TokenTypes, typeIndices = intersectLegendNames(handler.TokenTypes, clientTypes)
+	legend.types = typeIndices
+
+	var modifierIndices []int
+	legend.client.TokenModifiers, modifierIndices = intersectLegendNames(handler.TokenModifiers, clientModifiers)
+	legend.modifiers = make([]uint, len(modifierIndices))
+	for index, clientIndex := range modifierIndices {
+		if clientIndex >= 0 {
+			legend.modifiers[index] = 1 << uint(clientIndex)
+		}
+	}
+
+	return legend, nil
+}
+
+func validateLegendNames(kind string, names []string) error {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("semantic tokens legend: empty %s", kind)
+		}
+		if seen[name] {
+			return fmt.Errorf("semantic tokens legend: duplicate %s %q", kind, name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// intersectLegendNames keeps the names that are declared, in their original order. The indices have the new
+// index of each name, or -1 if it was removed.
+func intersectLegendNames(names []string, declared []string) ([]string, []int) {
+	indices := make([]int, len(names))
+
+	if len(declared) == 0 {
+		for index := range names {
+			indices[index] = index
+		}
+		return names, indices
+	}
+
+	isDeclared := make(map[string]bool, len(declared))
+	for _, name := range declared {
+		isDeclared[name] = true
+	}
+
+	kept := make([]string, 0, len(names))
+	for index, name := range names {
+		if !isDeclared[name] {
+			indices[index] = -1
+			continue
+		}
+		indices[index] = len(kept)
+		kept = append(kept, name)
+	}
+
+	return kept, indices
+}
+
+// remap validates the tokens encoded by the handler and encodes them against the client legend. Tokens of
+// types unknown to the client are dropped, and so are modifiers unknown to the client.
+func (l *semanticTokensLegend) remap(tokens *lsp.SemanticTokens) (*lsp.SemanticTokens, error) {
+	if tokens == nil {
+		return nil, nil
+	}
+
+	decoded, err := decodeSemanticTokens(tokens.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	remapped := decoded[:0]
+	for _, token := range decoded {
+		if token.tokenType >= uint(len(l.types)) {
+			return nil, fmt.Errorf("semantic tokens: token type %d at %d:%d is not in the legend", token.tokenType, token.line, token.character)
+		}
+
+		if token.modifiers>>uint(len(l.modifiers)) != 0 {
+			return nil, fmt.Errorf("semantic tokens: token modifiers %b at %d:%d are not in the legend", token.modifiers, token.line, token.character)
+		}
+
+		clientType := l.types[token.tokenType]
+		if clientType < 0 {
+			continue
+		}
+
+		var modifiers uint
+		for index, bit := range l.modifiers {
+			if token.modifiers&(1<<uint(index)) != 0 {
+				modifiers |= bit
+			}
+		}
+
+		token.tokenType = uint(clientType)
+		token.modifiers = modifiers
+		remapped = append(remapped, token)
+	}
+
+	return &lsp.SemanticTokens{ResultId: tokens.ResultId, Data: encodeSemanticTokens(remapped)}, nil
+}
+
+// encodedSemanticToken is a token of the semantic tokens data, with an absolute position.
+type encodedSemanticToken struct {
+	line      uint
+	character uint
+	length    uint
+	tokenType uint
+	modifiers uint
+}
+
+func decodeSemanticTokens(data []uint) ([]encodedSemanticToken, error) {
+	if len(data)%5 != 0 {
+		return nil, fmt.Errorf("semantic tokens: data length %d is not a multiple of 5", len(data))
+	}
+
+	tokens := make([]encodedSemanticToken, 0, len(data)/5)
+	var line, character uint
+	for index := 0; index < len(data); index += 5 {
+		if data[index] != 0 {
+			character = 0
+		}
+		line += data[index]
+		character += data[index+1]
+
+		tokens = append(tokens, encodedSemanticToken{
+			line:      line,
+			character: character,
+			length:    data[index+2],
+			tokenType: data[index+3],
+			modifiers: data[index+4],
+		})
+	}
+
+	return tokens, nil
+}
+
+// encodeSemanticTokens encodes tokens sorted by position into the relative format of the semantic tokens data.
+func encodeSemanticTokens(tokens []encodedSemanticToken) []uint {
+	data := make([]uint, 0, len(tokens)*5)
+	var previousLine, previousCharacter uint
+	for _, token := range tokens {
+		deltaCharacter := token.character
+		if token.line == previousLine {
+			deltaCharacter -= previousCharacter
+		}
+
+		data = append(data, token.line-previousLine, deltaCharacter, token.length, token.tokenType, token.modifiers)
+
+		previousLine = token.line
+		previousCharacter = token.character
+	}
+
+	return data
+}
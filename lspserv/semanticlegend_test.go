@@ -0,0 +1,160 @@
+package lspserv
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/piot/go-lsp"
+)
+
+func TestNewSemanticTokensLegend(t *testing.T) {
+	handler := lsp.SemanticTokensLegend{
+		TokenTypes:     []string{"namespace", "lifetime", "enum"},
+		TokenModifiers: []string{"declaration", "mutable", "definition"},
+	}
+
+	for _, test := range []struct {
+		name            string
+		clientTypes     []string
+		clientModifiers []string
+		expected        lsp.SemanticTokensLegend
+		types           []int
+		modifiers       []uint
+	}{
+		{
+			name:      "client without declared types",
+			expected:  handler,
+			types:     []int{0, 1, 2},
+			modifiers: []uint{1, 2, 4},
+		},
+		{
+			name:            "intersection in handler order",
+			clientTypes:     []string{"enum", "namespace", "class"},
+			clientModifiers: []string{"definition", "declaration"},
+			expected:        lsp.SemanticTokensLegend{TokenTypes: []string{"namespace", "enum"}, TokenModifiers: []string{"declaration", "definition"}},
+			types:           []int{0, -1, 1},
+			modifiers:       []uint{1, 0, 2},
+		},
+	} {
+		legend, err := newSemanticTokensLegend(handler, test.clientTypes, test.clientModifiers)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(legend.client, test.expected) {
+			t.Errorf("%s: expected client legend %v, got %v", test.name, test.expected, legend.client)
+		}
+		if !reflect.DeepEqual(legend.types, test.types) || !reflect.DeepEqual(legend.modifiers, test.modifiers) {
+			t.Errorf("%s: expected mapping %v %v, got %v %v", test.name, test.types, test.modifiers, legend.types, legend.modifiers)
+		}
+	}
+}
+
+func TestNewSemanticTokensLegendErrors(t *testing.T) {
+	manyModifiers := make([]string, 33)
+	for index := range manyModifiers {
+		manyModifiers[index] = fmt.Sprintf("modifier%d", index)
+	}
+
+	for name, legend := range map[string]lsp.SemanticTokensLegend{
+		"duplicate type":     {TokenTypes: []string{"enum", "enum"}},
+		"empty type":         {TokenTypes: []string{""}},
+		"duplicate modifier": {TokenModifiers: []string{"static", "static"}},
+		"too many modifiers": {TokenModifiers: manyModifiers},
+	} {
+		if _, err := newSemanticTokensLegend(legend, nil, nil); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSemanticTokensLegendRemap(t *testing.T) {
+	handler := lsp.SemanticTokensLegend{
+		TokenTypes:     []string{"namespace", "lifetime", "enum"},
+		TokenModifiers: []string{"declaration", "mutable", "definition"},
+	}
+
+	for _, test := range []struct {
+		name        string
+		clientTypes []string
+		data        []uint
+		expected    []uint
+	}{
+		{
+			name:     "unchanged for a client without declared types",
+			data:     []uint{0, 0, 5, 0, 3, 0, 6, 2, 1, 2, 1, 2, 3, 2, 4},
+			expected: []uint{0, 0, 5, 0, 3, 0, 6, 2, 1, 2, 1, 2, 3, 2, 4},
+		},
+		{
+			name:        "dropped token in the middle of a line",
+			clientTypes: []string{"namespace", "enum"},
+			data:        []uint{0, 0, 5, 0, 3, 0, 6, 2, 1, 2, 0, 3, 3, 2, 4},
+			expected:    []uint{0, 0, 5, 0, 3, 0, 9, 3, 1, 4},
+		},
+		{
+			name:        "dropped token starting a line",
+			clientTypes: []string{"namespace", "enum"},
+			data:        []uint{0, 0, 5, 0, 0, 1, 2, 2, 1, 0, 0, 3, 3, 2, 0, 2, 1, 1, 0, 0},
+			expected:    []uint{0, 0, 5, 0, 0, 1, 5, 3, 1, 0, 2, 1, 1, 0, 0},
+		},
+		{
+			name:        "modifiers unknown to the client are removed",
+			clientTypes: []string{"enum"},
+			data:        []uint{0, 4, 3, 2, 7},
+			expected:    []uint{0, 4, 3, 0, 7},
+		},
+	} {
+		legend, err := newSemanticTokensLegend(handler, test.clientTypes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens, err := legend.remap(&lsp.SemanticTokens{Data: test.data})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens.Data, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, tokens.Data)
+		}
+	}
+}
+
+func TestSemanticTokensLegendRemapErrors(t *testing.T) {
+	legend, err := newSemanticTokensLegend(lsp.SemanticTokensLegend{TokenTypes: []string{"enum"}, TokenModifiers: []string{"static"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]uint{
+		"type out of range":     {0, 0, 1, 1, 0},
+		"modifier out of range": {0, 0, 1, 0, 2},
+		"incomplete token":      {0, 0, 1, 0},
+	} {
+		if _, err := legend.remap(&lsp.SemanticTokens{Data: data}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDecodeSemanticTokens(t *testing.T) {
+	data := []uint{1, 2, 3, 0, 1, 0, 4, 1, 2, 0, 2, 1, 5, 1, 3}
+	expected := []encodedSemanticToken{
+		{line: 1, character: 2, length: 3, tokenType: 0, modifiers: 1},
+		{line: 1, character: 6, length: 1, tokenType: 2, modifiers: 0},
+		{line: 3, character: 1, length: 5, tokenType: 1, modifiers: 3},
+	}
+
+	tokens, err := decodeSemanticTokens(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("expected %v, got %v", expected, tokens)
+	}
+
+	if encoded := encodeSemanticTokens(tokens); !reflect.DeepEqual(encoded, data) {
+		t.Errorf("expected the round trip to give %v, got %v", data, encoded)
+	}
+}
@@ -0,0 +1,236 @@
+package lspserv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/piot/go-lsp"
+)
+
+// DefaultSemanticTokensLegend has the token types and modifiers predefined by the specification.
+var DefaultSemanticTokensLegend = lsp.SemanticTokensLegend{
+	TokenTypes: []string{
+		"namespace",
+		"type",
+		"class",
+		"enum",
+		"interface",
+		"struct",
+		"typeParameter",
+		"parameter",
+		"variable",
+		"property",
+		"enumMember",
+		"event",
+		"function",
+		"method",
+		"macro",
+		"keyword",
+		"modifier",
+		"comment",
+		"string",
+		"number",
+		"regexp",
+		"operator",
+	},
+	TokenModifiers: []string{
+		"declaration",
+		"definition",
+		"readonly",
+		"static",
+		"deprecated",
+		"abstract",
+		"async",
+		"modification",
+		"documentation",
+		"defaultLibrary",
+	},
+}
+
+type semanticToken struct {
+	start     lsp.Position
+	end       lsp.Position
+	tokenType string
+	modifiers []string
+}
+
+// SemanticTokensBuilder collects tokens with absolute positions and named types and modifiers, and encodes
+// them into the relative format of `textDocument/semanticTokens`. Get one from Connection.SemanticTokensBuilder
+// to use the legend of the handler and the capabilities of the client.
+type SemanticTokensBuilder struct {
+	legend                lsp.SemanticTokensLegend
+	multilineTokenSupport bool
+	// lineLengths and lineBreakLengths are in UTF-16 code units and only known if the text was given.
+	lineLengths      []int
+	lineBreakLengths []int
+	tokens           []semanticToken
+}
+
+// NewSemanticTokensBuilder encodes against legend. The text of the document is only needed for tokens
+// spanning several lines, which are split into one token per line unless multilineTokenSupport is set.
+func NewSemanticTokensBuilder(legend lsp.SemanticTokensLegend, multilineTokenSupport bool, text string) *SemanticTokensBuilder {
+	b := &SemanticTokensBuilder{legend: legend, multilineTokenSupport: multilineTokenSupport}
+
+	if text != "" {
+		lines := strings.Split(text, "\n")
+		for index, line := range lines {
+			lineBreakLength := 1
+			if strings.HasSuffix(line, "\r") {
+				line = line[:len(line)-1]
+				lineBreakLength = 2
+			}
+			if index == len(lines)-1 {
+				lineBreakLength = 0
+			}
+
+			length := 0
+			for _, r := range line {
+				length += utf16Length(r)
+			}
+
+			b.lineLengths = append(b.lineLengths, length)
+			b.lineBreakLengths = append(b.lineBreakLengths, lineBreakLength)
+		}
+	}
+
+	return b
+}
+
+// Add adds a token on a single line. Length and character are in UTF-16 code units.
+func (b *SemanticTokensBuilder) Add(line int, character int, length int, tokenType string, modifiers ...string) {
+	b.tokens = append(b.tokens, semanticToken{
+		start:     lsp.Position{Line: line, Character: character},
+		end:       lsp.Position{Line: line, Character: character + length},
+		tokenType: tokenType,
+		modifiers: modifiers,
+	})
+}
+
+// AddRange adds a token that may span several lines, e.g. a block comment.
+func (b *SemanticTokensBuilder) AddRange(r lsp.Range, tokenType string, modifiers ...string) {
+	b.tokens = append(b.tokens, semanticToken{start: r.Start, end: r.End, tokenType: tokenType, modifiers: modifiers})
+}
+
+// Build sorts the tokens and encodes them. Tokens overlapping a previous token are dropped, since clients
+// do not support overlapping tokens. It fails for types and modifiers that are not in the legend.
+func (b *SemanticTokensBuilder) Build() (*lsp.SemanticTokens, error) {
+	typeIndices := make(map[string]uint, len(b.legend.TokenTypes))
+	for index, name := range b.legend.TokenTypes {
+		typeIndices[name] = uint(index)
+	}
+
+	modifierBits := make(map[string]uint, len(b.legend.TokenModifiers))
+	for index, name := range b.legend.TokenModifiers {
+		modifierBits[name] = 1 << uint(index)
+	}
+
+	lineTokens, err := b.lineTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]encodedSemanticToken, 0, len(lineTokens))
+	previousEnd := lsp.Position{Line: -1}
+
+	for _, token := range lineTokens {
+		if positionBefore(token.start, previousEnd) {
+			continue
+		}
+
+		tokenType, ok := typeIndices[token.tokenType]
+		if !ok {
+			return nil, fmt.Errorf("SemanticTokensBuilder: token type %q is not in the legend", token.tokenType)
+		}
+
+		var modifiers uint
+		for _, modifier := range token.modifiers {
+			bit, ok := modifierBits[modifier]
+			if !ok {
+				return nil, fmt.Errorf("SemanticTokensBuilder: token modifier %q is not in the legend", modifier)
+			}
+			modifiers |= bit
+		}
+
+		length, err := b.length(token)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = append(encoded, encodedSemanticToken{
+			line:      uint(token.start.Line),
+			character: uint(token.start.Character),
+			length:    uint(length),
+			tokenType: tokenType,
+			modifiers: modifiers,
+		})
+
+		previousEnd = token.end
+	}
+
+	return &lsp.SemanticTokens{Data: encodeSemanticTokens(encoded)}, nil
+}
+
+// lineTokens sorts the tokens, split into one token per line if the client does not support multiline tokens.
+func (b *SemanticTokensBuilder) lineTokens() ([]semanticToken, error) {
+	tokens := make([]semanticToken, 0, len(b.tokens))
+
+	for _, token := range b.tokens {
+		if token.end.Line == token.start.Line || b.multilineTokenSupport {
+			tokens = append(tokens, token)
+			continue
+		}
+
+		if token.end.Line >= len(b.lineLengths) {
+			return nil, fmt.Errorf("SemanticTokensBuilder: splitting the token at %v needs the text of the document", token.start)
+		}
+
+		for line := token.start.Line; line <= token.end.Line; line++ {
+			start := lsp.Position{Line: line}
+			if line == token.start.Line {
+				start.Character = token.start.Character
+			}
+			end := lsp.Position{Line: line, Character: b.lineLengths[line]}
+			if line == token.end.Line {
+				end.Character = token.end.Character
+			}
+			if end.Character > start.Character {
+				tokens = append(tokens, semanticToken{start: start, end: end, tokenType: token.tokenType, modifiers: token.modifiers})
+			}
+		}
+	}
+
+	sort.SliceStable(tokens, func(a, c int) bool {
+		return positionBefore(tokens[a].start, tokens[c].start)
+	})
+
+	return tokens, nil
+}
+
+// length is the number of UTF-16 code units of the token, including the line breaks of multiline tokens.
+func (b *SemanticTokensBuilder) length(token semanticToken) (int, error) {
+	if token.start.Line == token.end.Line {
+		return token.end.Character - token.start.Character, nil
+	}
+
+	if token.end.Line >= len(b.lineLengths) {
+		return 0, fmt.Errorf("SemanticTokensBuilder: the length of the token at %v needs the text of the document", token.start)
+	}
+
+	length := b.lineLengths[token.start.Line] - token.start.Character + b.lineBreakLengths[token.start.Line]
+	for line := token.start.Line + 1; line < token.end.Line; line++ {
+		length += b.lineLengths[line] + b.lineBreakLengths[line]
+	}
+
+	return length + token.end.Character, nil
+}
+
+// SemanticTokensBuilder returns a builder for the legend of the handler, which splits multiline tokens unless
+// the client supports them. If s is not part of a request, it uses DefaultSemanticTokensLegend and splits them.
+func (s *SendOut) SemanticTokensBuilder(text string) *SemanticTokensBuilder {
+	if s.requests == nil {
+		return NewSemanticTokensBuilder(DefaultSemanticTokensLegend, false, text)
+	}
+
+	return NewSemanticTokensBuilder(s.requests.handlerSemanticTokensLegend(), s.requests.clientCapabilities.TextDocument.SemanticTokens.MultilineTokenSupport, text)
+}
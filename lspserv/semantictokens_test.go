@@ -0,0 +1,110 @@
+package lspserv_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/piot/go-lsp"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+func TestSemanticTokensBuilderOutsideOfRequest(t *testing.T) {
+	builder := lspserv.NewSendOut(nil, nil).SemanticTokensBuilder("")
+	builder.Add(0, 0, 5, "namespace", "declaration")
+
+	tokens, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint{0, 0, 5, 0, 1}; !reflect.DeepEqual(tokens.Data, expected) {
+		t.Errorf("expected %v, got %v", expected, tokens.Data)
+	}
+}
+
+func TestSemanticTokensBuilder(t *testing.T) {
+	legend := lsp.SemanticTokensLegend{TokenTypes: []string{"keyword", "comment"}, TokenModifiers: []string{"static", "readonly"}}
+	comment := lsp.Range{Start: lsp.Position{Line: 0, Character: 4}, End: lsp.Position{Line: 1, Character: 2}}
+
+	for _, test := range []struct {
+		name      string
+		multiline bool
+		text      string
+		add       func(b *lspserv.SemanticTokensBuilder)
+		expected  []uint
+	}{
+		{
+			name: "sorted relative to the previous token",
+			add: func(b *lspserv.SemanticTokensBuilder) {
+				b.Add(2, 3, 1, "keyword", "readonly")
+				b.Add(0, 1, 2, "keyword")
+				b.Add(0, 5, 2, "comment", "static", "readonly")
+			},
+			expected: []uint{0, 1, 2, 0, 0, 0, 4, 2, 1, 3, 2, 3, 1, 0, 2},
+		},
+		{
+			name: "overlapping tokens are dropped",
+			add: func(b *lspserv.SemanticTokensBuilder) {
+				b.Add(0, 0, 4, "keyword")
+				b.Add(0, 2, 4, "comment")
+				b.Add(0, 4, 1, "comment")
+			},
+			expected: []uint{0, 0, 4, 0, 0, 0, 4, 1, 1, 0},
+		},
+		{
+			name: "multiline split per line",
+			text: "abc /* x\r\nyy */",
+			add: func(b *lspserv.SemanticTokensBuilder) {
+				b.AddRange(comment, "comment")
+			},
+			expected: []uint{0, 4, 4, 1, 0, 1, 0, 2, 1, 0},
+		},
+		{
+			name:      "multiline kept with the line break in the length",
+			multiline: true,
+			text:      "abc /* x\r\nyy */",
+			add: func(b *lspserv.SemanticTokensBuilder) {
+				b.AddRange(comment, "comment")
+			},
+			expected: []uint{0, 4, 8, 1, 0},
+		},
+		{
+			name: "utf-16 line length",
+			text: "\U0001F600x\ny",
+			add: func(b *lspserv.SemanticTokensBuilder) {
+				b.AddRange(lsp.Range{Start: lsp.Position{Line: 0, Character: 0}, End: lsp.Position{Line: 1, Character: 1}}, "comment")
+			},
+			expected: []uint{0, 0, 3, 1, 0, 1, 0, 1, 1, 0},
+		},
+	} {
+		builder := lspserv.NewSemanticTokensBuilder(legend, test.multiline, test.text)
+		test.add(builder)
+
+		tokens, err := builder.Build()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens.Data, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, tokens.Data)
+		}
+	}
+}
+
+func TestSemanticTokensBuilderErrors(t *testing.T) {
+	legend := lsp.SemanticTokensLegend{TokenTypes: []string{"keyword"}, TokenModifiers: []string{"static"}}
+
+	for name, add := range map[string]func(b *lspserv.SemanticTokensBuilder){
+		"unknown type":     func(b *lspserv.SemanticTokensBuilder) { b.Add(0, 0, 1, "lifetime") },
+		"unknown modifier": func(b *lspserv.SemanticTokensBuilder) { b.Add(0, 0, 1, "keyword", "mutable") },
+		"multiline without text": func(b *lspserv.SemanticTokensBuilder) {
+			b.AddRange(lsp.Range{End: lsp.Position{Line: 1}}, "keyword")
+		},
+	} {
+		builder := lspserv.NewSemanticTokensBuilder(legend, false, "")
+		add(builder)
+		if _, err := builder.Build(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
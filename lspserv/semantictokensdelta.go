@@ -0,0 +1,189 @@
+package lspserv
+
+import (
+	"strconv"
+
+	"github.com/piot/go-lsp"
+)
+
+type SemanticTokensDeltaParams struct {
+	TextDocument     lsp.TextDocumentIdentifier `json:"textDocument"`
+	PreviousResultID string                     `json:"previousResultId"`
+}
+
+// SemanticTokensEdit replaces DeleteCount integers of the previous data, starting at Start, with Data.
+type SemanticTokensEdit struct {
+	Start       uint   `json:"start"`
+	DeleteCount uint   `json:"deleteCount"`
+	Data        []uint `json:"data,omitempty"`
+}
+
+type SemanticTokensDelta struct {
+	ResultID string               `json:"resultId,omitempty"`
+	Edits    []SemanticTokensEdit `json:"edits"`
+}
+
+type SemanticTokensRangeParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	Range        lsp.Range                  `json:"range"`
+}
+
+// SemanticTokensRangeHandler can optionally be implemented by a Handler to serve `textDocument/semanticTokens/range`
+// without computing the tokens of the whole document. Without it, the dispatcher computes the full tokens and
+// only replies with the ones in the range.
+type SemanticTokensRangeHandler interface {
+	HandleSemanticTokensRange(params SemanticTokensRangeParams, conn Connection) (*lsp.SemanticTokens, error)
+}
+
+// semanticTokensCache keeps the last full result of every document, so `textDocument/semanticTokens/full/delta`
+// can reply with the edits to the result the client already has.
+type semanticTokensCache struct {
+	lastResultID uint64
+	documents    map[lsp.DocumentURI]cachedSemanticTokens
+}
+
+type cachedSemanticTokens struct {
+	resultID string
+	data     []uint
+}
+
+// store remembers the data as the latest result of the document and returns its result ID.
+func (c *semanticTokensCache) store(uri lsp.DocumentURI, data []uint) string {
+	if c.documents == nil {
+		c.documents = make(map[lsp.DocumentURI]cachedSemanticTokens)
+	}
+
+	c.lastResultID++
+	resultID := strconv.FormatUint(c.lastResultID, 10)
+	c.documents[uri] = cachedSemanticTokens{resultID: resultID, data: data}
+
+	return resultID
+}
+
+// lookup returns the data of the result with the ID, if it is still the latest result of the document.
+func (c *semanticTokensCache) lookup(uri lsp.DocumentURI, resultID string) ([]uint, bool) {
+	cached, ok := c.documents[uri]
+	if !ok || resultID == "" || cached.resultID != resultID {
+		return nil, false
+	}
+
+	return cached.data, true
+}
+
+func (c *semanticTokensCache) remove(uri lsp.DocumentURI) {
+	delete(c.documents, uri)
+}
+
+// semanticTokensFull gets the tokens of the document from the handler, remapped to the client legend, and
+// caches them under a new result ID.
+func (h *HandleLspRequests) semanticTokensFull(params lsp.SemanticTokensParams, conn Connection) (*lsp.SemanticTokens, error) {
+	tokens, err := h.handler.HandleSemanticTokensFull(params, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err = h.semanticTokensLegend.remap(tokens)
+	if err != nil || tokens == nil {
+		h.semanticTokensCache.remove(params.TextDocument.URI)
+		return nil, err
+	}
+
+	tokens.ResultId = h.semanticTokensCache.store(params.TextDocument.URI, tokens.Data)
+
+	return tokens, nil
+}
+
+// semanticTokensDelta replies with the edits to the previous result, or with the full tokens if that result is
+// no longer cached.
+func (h *HandleLspRequests) semanticTokensDelta(params SemanticTokensDeltaParams, conn Connection) (interface{}, error) {
+	previous, hasPrevious := h.semanticTokensCache.lookup(params.TextDocument.URI, params.PreviousResultID)
+
+	tokens, err := h.semanticTokensFull(lsp.SemanticTokensParams{TextDocument: params.TextDocument}, conn)
+	if err != nil || tokens == nil {
+		return nil, err
+	}
+
+	if !hasPrevious {
+		return tokens, nil
+	}
+
+	return &SemanticTokensDelta{ResultID: tokens.ResultId, Edits: semanticTokensEdits(previous, tokens.Data)}, nil
+}
+
+// semanticTokensEdits is a single edit replacing everything between the common prefix and suffix, which is
+// usually small since an edit of the document only changes the tokens around it. The edit is aligned to
+// whole tokens.
+func semanticTokensEdits(previous []uint, current []uint) []SemanticTokensEdit {
+	prefix := 0
+	for prefix < len(previous) && prefix < len(current) && previous[prefix] == current[prefix] {
+		prefix++
+	}
+	prefix -= prefix % 5
+
+	if prefix == len(previous) && prefix == len(current) {
+		return []SemanticTokensEdit{}
+	}
+
+	suffix := 0
+	for suffix < len(previous)-prefix && suffix < len(current)-prefix &&
+		previous[len(previous)-1-suffix] == current[len(current)-1-suffix] {
+		suffix++
+	}
+	suffix -= suffix % 5
+
+	return []SemanticTokensEdit{{
+		Start:       uint(prefix),
+		DeleteCount: uint(len(previous) - prefix - suffix),
+		Data:        current[prefix : len(current)-suffix],
+	}}
+}
+
+// semanticTokensRange uses the SemanticTokensRangeHandler if the handler implements it, and otherwise filters
+// the full tokens. Range results are not cached, since deltas are always relative to a full result.
+func (h *HandleLspRequests) semanticTokensRange(params SemanticTokensRangeParams, conn Connection) (*lsp.SemanticTokens, error) {
+	if rangeHandler, ok := h.handler.(SemanticTokensRangeHandler); ok {
+		tokens, err := rangeHandler.HandleSemanticTokensRange(params, conn)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens, err = h.semanticTokensLegend.remap(tokens)
+		if err != nil || tokens == nil {
+			return nil, err
+		}
+		tokens.ResultId = ""
+
+		return tokens, nil
+	}
+
+	tokens, err := h.handler.HandleSemanticTokensFull(lsp.SemanticTokensParams{TextDocument: params.TextDocument}, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err = h.semanticTokensLegend.remap(tokens)
+	if err != nil || tokens == nil {
+		return nil, err
+	}
+
+	decoded, err := decodeSemanticTokens(tokens.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	inRange := decoded[:0]
+	for _, token := range decoded {
+		if semanticTokenOverlaps(token, params.Range) {
+			inRange = append(inRange, token)
+		}
+	}
+
+	return &lsp.SemanticTokens{Data: encodeSemanticTokens(inRange)}, nil
+}
+
+func semanticTokenOverlaps(token encodedSemanticToken, r lsp.Range) bool {
+	start := lsp.Position{Line: int(token.line), Character: int(token.character)}
+	end := lsp.Position{Line: int(token.line), Character: int(token.character + token.length)}
+
+	return positionBefore(start, r.End) && positionBefore(r.Start, end)
+}
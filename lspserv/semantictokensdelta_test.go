@@ -0,0 +1,104 @@
+package lspserv
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/piot/go-lsp"
+)
+
+func TestSemanticTokensEdits(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		previous []uint
+		current  []uint
+		expected []SemanticTokensEdit
+	}{
+		{
+			name:     "identical",
+			previous: []uint{0, 0, 3, 0, 0, 1, 2, 4, 1, 0},
+			current:  []uint{0, 0, 3, 0, 0, 1, 2, 4, 1, 0},
+			expected: []SemanticTokensEdit{},
+		},
+		{
+			name:     "appended at the end",
+			previous: []uint{0, 0, 3, 0, 0},
+			current:  []uint{0, 0, 3, 0, 0, 1, 2, 4, 1, 0},
+			expected: []SemanticTokensEdit{{Start: 5, DeleteCount: 0, Data: []uint{1, 2, 4, 1, 0}}},
+		},
+		{
+			name:     "inserted in the middle",
+			previous: []uint{0, 0, 3, 0, 0, 1, 2, 4, 1, 0},
+			current:  []uint{0, 0, 3, 0, 0, 0, 4, 2, 2, 0, 1, 2, 4, 1, 0},
+			expected: []SemanticTokensEdit{{Start: 5, DeleteCount: 0, Data: []uint{0, 4, 2, 2, 0}}},
+		},
+		{
+			name:     "deleted in the middle",
+			previous: []uint{0, 0, 3, 0, 0, 0, 4, 2, 2, 0, 1, 2, 4, 1, 0},
+			current:  []uint{0, 0, 3, 0, 0, 1, 2, 4, 1, 0},
+			expected: []SemanticTokensEdit{{Start: 5, DeleteCount: 5, Data: []uint{}}},
+		},
+		{
+			name:     "changed inside a token",
+			previous: []uint{0, 0, 3, 0, 0, 0, 4, 3, 0, 0, 1, 2, 4, 1, 0},
+			current:  []uint{0, 0, 3, 0, 0, 0, 4, 5, 0, 0, 1, 2, 4, 1, 0},
+			expected: []SemanticTokensEdit{{Start: 5, DeleteCount: 5, Data: []uint{0, 4, 5, 0, 0}}},
+		},
+		{
+			name:     "changed first token with a common tail",
+			previous: []uint{0, 0, 3, 0, 0},
+			current:  []uint{0, 0, 4, 0, 0},
+			expected: []SemanticTokensEdit{{Start: 0, DeleteCount: 5, Data: []uint{0, 0, 4, 0, 0}}},
+		},
+		{
+			name:     "previous is empty",
+			current:  []uint{0, 0, 3, 0, 0},
+			expected: []SemanticTokensEdit{{Start: 0, DeleteCount: 0, Data: []uint{0, 0, 3, 0, 0}}},
+		},
+		{
+			name:     "current is empty",
+			previous: []uint{0, 0, 3, 0, 0},
+			current:  []uint{},
+			expected: []SemanticTokensEdit{{Start: 0, DeleteCount: 5, Data: []uint{}}},
+		},
+	} {
+		edits := semanticTokensEdits(test.previous, test.current)
+		if !reflect.DeepEqual(edits, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, edits)
+		}
+
+		if applied := applySemanticTokensEdits(test.previous, edits); !reflect.DeepEqual(applied, test.current) {
+			t.Errorf("%s: applying the edits gives %v, expected %v", test.name, applied, test.current)
+		}
+	}
+}
+
+func applySemanticTokensEdits(previous []uint, edits []SemanticTokensEdit) []uint {
+	result := append([]uint{}, previous...)
+	for _, edit := range edits {
+		tail := append([]uint{}, result[edit.Start+edit.DeleteCount:]...)
+		result = append(append(result[:edit.Start], edit.Data...), tail...)
+	}
+
+	return result
+}
+
+func TestSemanticTokenOverlaps(t *testing.T) {
+	token := encodedSemanticToken{line: 2, character: 4, length: 3}
+
+	for _, test := range []struct {
+		name     string
+		r        lsp.Range
+		expected bool
+	}{
+		{"containing line", lsp.Range{Start: lsp.Position{Line: 2}, End: lsp.Position{Line: 3}}, true},
+		{"ends at token start", lsp.Range{Start: lsp.Position{Line: 2}, End: lsp.Position{Line: 2, Character: 4}}, false},
+		{"starts at token end", lsp.Range{Start: lsp.Position{Line: 2, Character: 7}, End: lsp.Position{Line: 4}}, false},
+		{"inside token", lsp.Range{Start: lsp.Position{Line: 2, Character: 5}, End: lsp.Position{Line: 2, Character: 6}}, true},
+		{"earlier lines", lsp.Range{Start: lsp.Position{Line: 0}, End: lsp.Position{Line: 1, Character: 10}}, false},
+	} {
+		if overlaps := semanticTokenOverlaps(token, test.r); overlaps != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, overlaps)
+		}
+	}
+}
@@ -4,39 +4,45 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/piot/jsonrpc2"
 )
 
 type StdInOutReadWriteCloser struct {
 	logOutput bool
+
+	// Logger receives the raw payloads at debug level. RunUntilClose sets it to the logger of the service when it
+	// is nil, otherwise it defaults to stderr when logOutput is set.
+	Logger Logger
+}
+
+func (s StdInOutReadWriteCloser) log(message string, fields ...LogField) {
+	if s.Logger != nil {
+		s.Logger.Log(LogDebug, message, fields...)
+	} else if s.logOutput {
+		defaultLogger.Log(LogInfo, message, fields...)
+	}
 }
 
 func (s StdInOutReadWriteCloser) Read(p []byte) (int, error) {
 	n, err := os.Stdin.Read(p)
 
-	if s.logOutput {
-		log.Printf("read: '%v'\n", string(p[0:n]))
-	}
+	s.log("read", Field("payload", string(p[0:n])))
 
 	return n, err
 }
 
 func (s StdInOutReadWriteCloser) Write(p []byte) (int, error) {
-	if s.logOutput {
-		log.Printf("write: '%v'\n", string(p))
-	}
+	s.log("write", Field("payload", string(p)))
 
 	return os.Stdout.Write(p)
 }
 
 func (s StdInOutReadWriteCloser) Close() error {
-	if s.logOutput {
-		log.Printf("close\n")
-	}
+	s.log("close")
 
 	if err := os.Stdin.Close(); err != nil {
 		return err
@@ -46,23 +52,144 @@ func (s StdInOutReadWriteCloser) Close() error {
 }
 
 type Service interface {
+	// RunUntilClose serves rwc until the connection is closed. It returns ErrExitWithoutShutdown if the client
+	// sent `exit` without `shutdown`, in which case the process should exit with code 1.
 	RunUntilClose(rwc io.ReadWriteCloser, logOutput bool) error
+	// SetRequestTimeout bounds how long a request of the given method may run. A timed out request is answered
+	// right away, but the next message waits until its handler has returned. Must be called before RunUntilClose.
+	SetRequestTimeout(method string, timeout time.Duration)
+	// SetSlowRequestThreshold logs requests that take longer than threshold. Must be called before RunUntilClose.
+	SetSlowRequestThreshold(threshold time.Duration)
+	// AddInterceptor wraps the dispatch of every request and notification. Must be called before RunUntilClose.
+	AddInterceptor(interceptor Interceptor)
+	// SetLogger replaces the default stderr logger. Must be called before RunUntilClose.
+	SetLogger(logger Logger)
+	// ForwardLogToClient sends log lines at or above minLevel to the client with `window/logMessage`
+	// instead of the logger. Must be called before RunUntilClose.
+	ForwardLogToClient(minLevel LogLevel)
+	// RecordTo records every message of the session. When not set, the session is recorded to the file named
+	// by the LSPSERV_RECORD environment variable, if any. Must be called before RunUntilClose.
+	RecordTo(recorder *Recorder)
+	// RegisterRequest serves a custom request, e.g. `swamp/expandMacro`. Must be called before RunUntilClose.
+	RegisterRequest(method string, fn RequestFunc)
+	// RegisterNotification receives a custom notification. Must be called before RunUntilClose.
+	RegisterNotification(method string, fn NotificationFunc)
+	// SetStrictParams rejects params with unknown fields as InvalidParams. Must be called before RunUntilClose.
+	SetStrictParams(strict bool)
+	// SetSymbolIndex answers `workspace/symbol` from index, unless the handler implements WorkspaceSymbolHandler.
+	// Must be called before RunUntilClose.
+	SetSymbolIndex(index *SymbolIndex)
+	// RegisterCommand serves a command executed with `workspace/executeCommand` and lists it in the
+	// capabilities. Must be called before RunUntilClose.
+	RegisterCommand(name string, fn CommandFunc)
+	// SetDiagnosticsManager analyses documents when they are opened or changed and clears their diagnostics
+	// when they are closed. The manager is stopped when the connection closes. Must be called before RunUntilClose.
+	SetDiagnosticsManager(manager *DiagnosticsManager)
 }
 
 type serviceWrapper struct {
-	lspRequests *HandleLspRequests
+	lspRequests        *HandleLspRequests
+	logger             Logger
+	forwardLogToClient bool
+	clientLogLevel     LogLevel
+	recorder           *Recorder
 }
 
 func NewService(implementationHandler Handler) Service {
-	return &serviceWrapper{lspRequests: NewLspRequests(implementationHandler)}
+	return &serviceWrapper{lspRequests: NewLspRequests(implementationHandler), logger: defaultLogger}
+}
+
+func (s *serviceWrapper) SetRequestTimeout(method string, timeout time.Duration) {
+	s.lspRequests.SetRequestTimeout(method, timeout)
+}
+
+func (s *serviceWrapper) SetSlowRequestThreshold(threshold time.Duration) {
+	s.lspRequests.SetSlowRequestThreshold(threshold)
+}
+
+func (s *serviceWrapper) AddInterceptor(interceptor Interceptor) {
+	s.lspRequests.AddInterceptor(interceptor)
+}
+
+func (s *serviceWrapper) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+func (s *serviceWrapper) ForwardLogToClient(minLevel LogLevel) {
+	s.forwardLogToClient = true
+	s.clientLogLevel = minLevel
+}
+
+func (s *serviceWrapper) RecordTo(recorder *Recorder) {
+	s.recorder = recorder
+}
+
+func (s *serviceWrapper) RegisterRequest(method string, fn RequestFunc) {
+	s.lspRequests.RegisterRequest(method, fn)
+}
+
+func (s *serviceWrapper) RegisterNotification(method string, fn NotificationFunc) {
+	s.lspRequests.RegisterNotification(method, fn)
+}
+
+func (s *serviceWrapper) SetStrictParams(strict bool) {
+	s.lspRequests.SetStrictParams(strict)
+}
+
+func (s *serviceWrapper) SetSymbolIndex(index *SymbolIndex) {
+	s.lspRequests.SetSymbolIndex(index)
+}
+
+func (s *serviceWrapper) RegisterCommand(name string, fn CommandFunc) {
+	s.lspRequests.RegisterCommand(name, fn)
+}
+
+func (s *serviceWrapper) SetDiagnosticsManager(manager *DiagnosticsManager) {
+	s.lspRequests.SetDiagnosticsManager(manager)
 }
 
 func (s *serviceWrapper) RunUntilClose(rwc io.ReadWriteCloser, logOutput bool) error {
 	var connOpt []jsonrpc2.ConnOpt
 
-	stdErrLogger := log.New(os.Stderr, "", log.LstdFlags)
+	// Messages are never forwarded to the client, since every forwarded line would be logged as a new message.
 	if logOutput {
-		connOpt = append(connOpt, jsonrpc2.LogMessages(stdErrLogger))
+		connOpt = append(connOpt, jsonrpc2.LogMessages(printfLogger{logger: s.logger, level: LogInfo}))
+	}
+
+	logger := s.logger
+	if s.forwardLogToClient {
+		toClient := newClientLogger(s.clientLogLevel, s.logger)
+		connOpt = append(connOpt, toClient.connOpt())
+		logger = toClient
+	}
+	s.lspRequests.SetLogger(logger)
+
+	// The payloads are never forwarded to the client, for the same reason as the messages.
+	switch stdio := rwc.(type) {
+	case StdInOutReadWriteCloser:
+		if stdio.Logger == nil {
+			stdio.Logger = s.logger
+			rwc = stdio
+		}
+	case *StdInOutReadWriteCloser:
+		if stdio.Logger == nil {
+			stdio.Logger = s.logger
+		}
+	}
+
+	recorder := s.recorder
+	if recorder == nil {
+		if path := os.Getenv(RecordEnvironmentVariable); path != "" {
+			fileRecorder, err := NewFileRecorder(path)
+			if err != nil {
+				return err
+			}
+			defer fileRecorder.Close()
+			recorder = fileRecorder
+		}
+	}
+	if recorder != nil {
+		connOpt = append(connOpt, recorder.connOpts()...)
 	}
 
 	closer := ioutil.NopCloser(strings.NewReader(""))
@@ -72,9 +199,17 @@ func (s *serviceWrapper) RunUntilClose(rwc io.ReadWriteCloser, logOutput bool) e
 
 	<-connection.DisconnectNotify()
 
+	if s.lspRequests.diagnostics != nil {
+		s.lspRequests.diagnostics.Stop()
+	}
+
 	err := closer.Close()
 	if err != nil {
-		log.Println(err)
+		logger.Log(LogError, "RunUntilClose: close failed", Field("error", err))
+	}
+
+	if s.lspRequests.lifecycle.exitedWithoutShutdown() {
+		return ErrExitWithoutShutdown
 	}
 
 	return nil
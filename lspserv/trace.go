@@ -0,0 +1,71 @@
+package lspserv
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/piot/go-lsp"
+)
+
+const (
+	TraceOff      lsp.Trace = "off"
+	TraceMessages lsp.Trace = "messages"
+	TraceVerbose  lsp.Trace = "verbose"
+)
+
+type SetTraceParams struct {
+	Value lsp.Trace `json:"value"`
+}
+
+// logTraceParams differs from lsp.LogTraceParams in that verbose is a string, as specified.
+type logTraceParams struct {
+	Message string `json:"message"`
+	Verbose string `json:"verbose,omitempty"`
+}
+
+// traceLevel is set by initialize and `$/setTrace` and read from handlers.
+type traceLevel struct {
+	value atomic.Value
+}
+
+func (t *traceLevel) Set(trace lsp.Trace) error {
+	switch trace {
+	case "":
+		trace = TraceOff
+	case TraceOff, TraceMessages, TraceVerbose:
+	default:
+		return fmt.Errorf("unknown trace value %q", trace)
+	}
+
+	t.value.Store(trace)
+
+	return nil
+}
+
+func (t *traceLevel) Get() lsp.Trace {
+	trace, _ := t.value.Load().(lsp.Trace)
+	if trace == "" {
+		return TraceOff
+	}
+
+	return trace
+}
+
+// LogTrace sends `$/logTrace` if the client has enabled tracing. The verbose text is only included
+// when the trace level is verbose.
+func (s *SendOut) LogTrace(message string, verbose string) error {
+	if s.requests == nil {
+		return nil
+	}
+
+	params := logTraceParams{Message: message}
+
+	switch s.requests.trace.Get() {
+	case TraceOff:
+		return nil
+	case TraceVerbose:
+		params.Verbose = verbose
+	}
+
+	return s.conn.Notify(s.ctx, "$/logTrace", params)
+}
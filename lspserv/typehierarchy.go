@@ -0,0 +1,35 @@
+package lspserv
+
+import "github.com/piot/go-lsp"
+
+// TypeHierarchyItem is a type in the type hierarchy view, e.g. a struct or a trait.
+type TypeHierarchyItem = HierarchyItem
+
+type TypeHierarchySupertypesParams struct {
+	Item TypeHierarchyItem `json:"item"`
+}
+
+type TypeHierarchySubtypesParams struct {
+	Item TypeHierarchyItem `json:"item"`
+}
+
+type TypeHierarchyOptions struct {
+	lsp.WorkDoneProgressOptions
+}
+
+// TypeHierarchyHandler can optionally be implemented by a Handler to serve `textDocument/prepareTypeHierarchy`,
+// `typeHierarchy/supertypes` and `typeHierarchy/subtypes`, e.g. the traits a struct implements and the
+// structs embedding it.
+type TypeHierarchyHandler interface {
+	HandlePrepareTypeHierarchy(params lsp.TextDocumentPositionParams, conn Connection) ([]TypeHierarchyItem, error)
+	HandleTypeHierarchySupertypes(params TypeHierarchySupertypesParams, conn Connection) ([]TypeHierarchyItem, error)
+	HandleTypeHierarchySubtypes(params TypeHierarchySubtypesParams, conn Connection) ([]TypeHierarchyItem, error)
+}
+
+func (h *HandleLspRequests) typeHierarchyProvider() *TypeHierarchyOptions {
+	if _, ok := h.handler.(TypeHierarchyHandler); !ok {
+		return nil
+	}
+
+	return &TypeHierarchyOptions{}
+}
@@ -0,0 +1,210 @@
+package lspserv
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/piot/go-lsp"
+)
+
+// WorkspaceSymbol is a symbol found by `workspace/symbol`. lsp.SymbolInformation does not match the
+// specification, so it can not be used.
+type WorkspaceSymbol struct {
+	Name          string                  `json:"name"`
+	Kind          lsp.SymbolKind          `json:"kind"`
+	Tags          []lsp.SymbolTag         `json:"tags,omitempty"`
+	ContainerName string                  `json:"containerName,omitempty"`
+	Location      WorkspaceSymbolLocation `json:"location"`
+	// Data is kept by the client and sent back in `workspaceSymbol/resolve`.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// WorkspaceSymbolLocation may leave out the range, which is then filled in by `workspaceSymbol/resolve`.
+type WorkspaceSymbolLocation struct {
+	URI   lsp.DocumentURI `json:"uri"`
+	Range *lsp.Range      `json:"range,omitempty"`
+}
+
+type WorkspaceSymbolOptions struct {
+	ResolveProvider bool `json:"resolveProvider,omitempty"`
+}
+
+// WorkspaceSymbolHandler is implemented by handlers that search symbols themselves. Handlers without it can
+// use a SymbolIndex instead.
+type WorkspaceSymbolHandler interface {
+	HandleWorkspaceSymbol(params lsp.WorkspaceSymbolParams, conn Connection) ([]WorkspaceSymbol, error)
+}
+
+// WorkspaceSymbolResolveHandler fills in the range of symbols returned without one.
+type WorkspaceSymbolResolveHandler interface {
+	HandleWorkspaceSymbolResolve(symbol WorkspaceSymbol, conn Connection) (*WorkspaceSymbol, error)
+}
+
+// SetSymbolIndex answers `workspace/symbol` from index, unless the handler implements WorkspaceSymbolHandler.
+// Must be called before RunUntilClose.
+func (h *HandleLspRequests) SetSymbolIndex(index *SymbolIndex) {
+	h.symbolIndex = index
+}
+
+// workspaceSymbolProvider is the capability to advertise, or nil if `workspace/symbol` is not supported.
+func (h *HandleLspRequests) workspaceSymbolProvider() interface{} {
+	_, hasHandler := h.handler.(WorkspaceSymbolHandler)
+	if !hasHandler && h.symbolIndex == nil {
+		return nil
+	}
+
+	if _, canResolve := h.handler.(WorkspaceSymbolResolveHandler); canResolve {
+		return WorkspaceSymbolOptions{ResolveProvider: true}
+	}
+
+	return true
+}
+
+func (h *HandleLspRequests) handleWorkspaceSymbol(params lsp.WorkspaceSymbolParams, conn Connection) ([]WorkspaceSymbol, error) {
+	if symbolHandler, ok := h.handler.(WorkspaceSymbolHandler); ok {
+		return symbolHandler.HandleWorkspaceSymbol(params, conn)
+	}
+
+	return h.symbolIndex.Search(params.Query), nil
+}
+
+// SymbolIndex keeps the symbols of every document and searches them with FuzzyMatch. Handlers replace the
+// symbols of a document whenever it has been parsed. It is safe for concurrent use.
+type SymbolIndex struct {
+	mu         sync.RWMutex
+	documents  map[lsp.DocumentURI][]WorkspaceSymbol
+	maxResults int
+}
+
+// NewSymbolIndex returns an empty index. Searches return at most maxResults symbols, or all of them
+// if maxResults is zero.
+func NewSymbolIndex(maxResults int) *SymbolIndex {
+	return &SymbolIndex{documents: make(map[lsp.DocumentURI][]WorkspaceSymbol), maxResults: maxResults}
+}
+
+// SetDocumentSymbols replaces all symbols of the document.
+func (i *SymbolIndex) SetDocumentSymbols(uri lsp.DocumentURI, symbols []WorkspaceSymbol) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.documents[uri] = symbols
+}
+
+func (i *SymbolIndex) RemoveDocument(uri lsp.DocumentURI) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.documents, uri)
+}
+
+// Search returns the symbols matching query, best matches first.
+func (i *SymbolIndex) Search(query string) []WorkspaceSymbol {
+	type match struct {
+		symbol WorkspaceSymbol
+		score  int
+	}
+
+	var matches []match
+
+	i.mu.RLock()
+	for _, symbols := range i.documents {
+		for _, symbol := range symbols {
+			if score, ok := FuzzyMatch(query, symbol.Name); ok {
+				matches = append(matches, match{symbol: symbol, score: score})
+			}
+		}
+	}
+	i.mu.RUnlock()
+
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].score != matches[b].score {
+			return matches[a].score > matches[b].score
+		}
+		if len(matches[a].symbol.Name) != len(matches[b].symbol.Name) {
+			return len(matches[a].symbol.Name) < len(matches[b].symbol.Name)
+		}
+		if matches[a].symbol.Name != matches[b].symbol.Name {
+			return matches[a].symbol.Name < matches[b].symbol.Name
+		}
+		return matches[a].symbol.Location.URI < matches[b].symbol.Location.URI
+	})
+
+	if i.maxResults > 0 && len(matches) > i.maxResults {
+		matches = matches[:i.maxResults]
+	}
+
+	result := make([]WorkspaceSymbol, len(matches))
+	for index, m := range matches {
+		result[index] = m.symbol
+	}
+
+	return result
+}
+
+// FuzzyMatch reports if all characters of query appear in candidate in the same order, ignoring case, e.g.
+// `gdef` matches `GotoDefinition`. Higher scores are better matches: exact matches and prefixes first,
+// then characters matched at the start of words and in consecutive runs. An empty query matches everything.
+func FuzzyMatch(query string, candidate string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+
+	queryRunes := []rune(strings.ToLower(query))
+	candidateRunes := []rune(candidate)
+
+	score := 0
+	queryIndex := 0
+	lastMatch := -1
+
+	for candidateIndex, r := range candidateRunes {
+		if queryIndex == len(queryRunes) {
+			break
+		}
+		if unicode.ToLower(r) != queryRunes[queryIndex] {
+			continue
+		}
+
+		score++
+		switch {
+		case candidateIndex == 0:
+			score += 8
+		case isWordStart(candidateRunes, candidateIndex):
+			score += 6
+		}
+		if lastMatch >= 0 && lastMatch == candidateIndex-1 {
+			score += 4
+		} else if lastMatch >= 0 {
+			score -= candidateIndex - lastMatch - 1
+		}
+
+		lastMatch = candidateIndex
+		queryIndex++
+	}
+
+	if queryIndex < len(queryRunes) {
+		return 0, false
+	}
+
+	lowerCandidate := strings.ToLower(candidate)
+	lowerQuery := string(queryRunes)
+	if lowerCandidate == lowerQuery {
+		score += 100
+	} else if strings.HasPrefix(lowerCandidate, lowerQuery) {
+		score += 50
+	}
+
+	return score, true
+}
+
+// isWordStart is true after a separator and at the upper case letter of a camel case word.
+func isWordStart(runes []rune, index int) bool {
+	previous := runes[index-1]
+	if previous == '_' || previous == '-' || previous == '.' || unicode.IsSpace(previous) {
+		return true
+	}
+
+	return unicode.IsUpper(runes[index]) && !unicode.IsUpper(previous)
+}
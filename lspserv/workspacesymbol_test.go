@@ -0,0 +1,54 @@
+package lspserv_test
+
+import (
+	"testing"
+
+	"github.com/piot/lsp-server/lspserv"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, test := range []struct {
+		query     string
+		candidate string
+		matches   bool
+	}{
+		{"", "Anything", true},
+		{"gdef", "GotoDefinition", true},
+		{"GDEF", "gotoDefinition", true},
+		{"goto", "GotoDefinition", true},
+		{"fedg", "GotoDefinition", false},
+		{"gotox", "Goto", false},
+		{"über", "ÜberType", true},
+		{"snake", "is_snake_case", true},
+	} {
+		_, matches := lspserv.FuzzyMatch(test.query, test.candidate)
+		if matches != test.matches {
+			t.Errorf("FuzzyMatch(%q, %q) matches %v, expected %v", test.query, test.candidate, matches, test.matches)
+		}
+	}
+}
+
+func TestFuzzyMatchRanking(t *testing.T) {
+	// Every query ranks the candidates in order, the best match first.
+	for _, test := range []struct {
+		query      string
+		candidates []string
+	}{
+		{"point", []string{"Point", "PointList", "SetPoint", "spoint"}},
+		{"gd", []string{"GotoDefinition", "GoodDay", "guard"}},
+		{"ab", []string{"ab", "aXb", "aXXXb"}},
+	} {
+		previous := 0
+		for index, candidate := range test.candidates {
+			score, matches := lspserv.FuzzyMatch(test.query, candidate)
+			if !matches {
+				t.Errorf("FuzzyMatch(%q, %q) does not match", test.query, candidate)
+				continue
+			}
+			if index > 0 && score >= previous {
+				t.Errorf("FuzzyMatch(%q, %q) scores %d, expected less than %q with %d", test.query, candidate, score, test.candidates[index-1], previous)
+			}
+			previous = score
+		}
+	}
+}